@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/Sydsvenskan/concourse"
+	"github.com/pkg/errors"
+)
+
+// atomicOutput runs fn with a context that writes to a temporary directory
+// inside the output directory. Once fn has succeeded the entries are
+// renamed into the output directory, which is kept as is since it's
+// typically a mount point.
+func atomicOutput(
+	ctx *concourse.CommandContext,
+	fn func(ctx *concourse.CommandContext) (*concourse.CommandResponse, error),
+) (*concourse.CommandResponse, error) {
+	dest, err := filepath.Abs(ctx.Directory())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to resolve output directory")
+	}
+
+	// Created inside the output directory so that the renames stay on
+	// the same filesystem.
+	tmp, err := ioutil.TempDir(dest, ".url-resource-")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temporary output directory")
+	}
+	defer os.RemoveAll(tmp)
+
+	tmpCtx, err := concourse.NewContext([]string{"in", tmp}, nil, nil, ctx.Log)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create command context")
+	}
+
+	resp, err := fn(tmpCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := ioutil.ReadDir(tmp)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list temporary output directory")
+	}
+
+	for _, entry := range entries {
+		err := os.Rename(
+			filepath.Join(tmp, entry.Name()),
+			filepath.Join(dest, entry.Name()),
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to move output into place")
+		}
+	}
+
+	return resp, nil
+}
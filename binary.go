@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// sniffLen is the number of bytes that are inspected for null bytes.
+const sniffLen = 8000
+
+// isBinaryFile uses the content type, and if that's inconclusive the
+// presence of null bytes, to determine if a file is binary.
+func isBinaryFile(filename, contentType string) (bool, error) {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		if strings.HasPrefix(mediaType, "text/") ||
+			strings.HasSuffix(mediaType, "json") ||
+			strings.HasSuffix(mediaType, "xml") ||
+			strings.HasSuffix(mediaType, "javascript") ||
+			strings.HasSuffix(mediaType, "yaml") {
+			return false, nil
+		}
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to open file")
+	}
+	defer f.Close()
+
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, errors.Wrap(err, "failed to read file")
+	}
+
+	return bytes.IndexByte(buf[:n], 0) != -1, nil
+}
+
+// writeBase64 writes a base64 encoded copy of a file to dst.
+func writeBase64(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.Wrap(err, "failed to open file")
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return errors.Wrap(err, "failed to create base64 file")
+	}
+	defer out.Close()
+
+	enc := base64.NewEncoder(base64.StdEncoding, out)
+	if _, err := io.Copy(enc, in); err != nil {
+		return errors.Wrap(err, "failed to write base64 file")
+	}
+	if err := enc.Close(); err != nil {
+		return errors.Wrap(err, "failed to write base64 file")
+	}
+
+	return errors.Wrap(out.Close(), "failed to write base64 file")
+}
+
+// executableMagics are the leading bytes of ELF, Mach-O (32 and 64 bit
+// in both byte orders, and universal), PE and script files.
+var executableMagics = [][]byte{
+	[]byte("\x7fELF"),
+	[]byte("\xfe\xed\xfa\xce"),
+	[]byte("\xfe\xed\xfa\xcf"),
+	[]byte("\xce\xfa\xed\xfe"),
+	[]byte("\xcf\xfa\xed\xfe"),
+	[]byte("\xca\xfe\xba\xbe"),
+	[]byte("MZ"),
+	[]byte("#!"),
+}
+
+// looksExecutable detects executables by their magic bytes.
+func looksExecutable(filename string) (bool, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to open file")
+	}
+	defer f.Close()
+
+	buf := make([]byte, 4)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, errors.Wrap(err, "failed to read file")
+	}
+	buf = buf[:n]
+
+	for _, magic := range executableMagics {
+		if bytes.HasPrefix(buf, magic) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// markExecutables sets the executable bits on the files in dir that look
+// executable and returns their names.
+func markExecutables(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list output directory")
+	}
+
+	var marked []string
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+
+		filename := filepath.Join(dir, entry.Name())
+		executable, err := looksExecutable(filename)
+		if err != nil {
+			return nil, err
+		}
+		if !executable {
+			continue
+		}
+
+		if err := os.Chmod(filename, entry.Mode()|0111); err != nil {
+			return nil, errors.Wrap(err, "failed to mark file as executable")
+		}
+		marked = append(marked, entry.Name())
+	}
+
+	return marked, nil
+}
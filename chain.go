@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// verifyCertificateChain walks the certificate chain presented by the
+// server and verifies validity period, key usage and signatures, logging
+// each step.
+func verifyCertificateChain(state *tls.ConnectionState, log io.Writer) error {
+	if state == nil {
+		return errors.New("response wasn't received over a TLS connection")
+	}
+
+	certs := state.PeerCertificates
+	if len(certs) == 0 {
+		return errors.New("the server didn't present any certificates")
+	}
+
+	now := time.Now()
+	for i, cert := range certs {
+		fmt.Fprintf(log, "certificate %d: %s\n", i, cert.Subject.CommonName)
+
+		if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+			return errors.Errorf(
+				"certificate %d is only valid between %s and %s",
+				i, cert.NotBefore, cert.NotAfter,
+			)
+		}
+		fmt.Fprintf(log, "  valid until %s\n", cert.NotAfter)
+
+		if i == 0 {
+			if err := verifyLeafUsage(cert); err != nil {
+				return errors.Wrapf(err, "certificate %d", i)
+			}
+		} else {
+			if err := verifyIssuerUsage(cert); err != nil {
+				return errors.Wrapf(err, "certificate %d", i)
+			}
+		}
+		fmt.Fprintln(log, "  key usage ok")
+
+		if i+1 < len(certs) {
+			if err := cert.CheckSignatureFrom(certs[i+1]); err != nil {
+				return errors.Wrapf(err,
+					"certificate %d isn't signed by certificate %d", i, i+1)
+			}
+			fmt.Fprintf(log, "  signed by %s\n", certs[i+1].Subject.CommonName)
+			continue
+		}
+
+		if bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+			if err := cert.CheckSignatureFrom(cert); err != nil {
+				return errors.Wrapf(err,
+					"self-signed certificate %d has an invalid signature", i)
+			}
+			fmt.Fprintln(log, "  self-signed")
+			continue
+		}
+
+		fmt.Fprintf(log, "  issued by %s, not included in the chain\n",
+			cert.Issuer.CommonName)
+	}
+
+	return nil
+}
+
+func verifyLeafUsage(cert *x509.Certificate) error {
+	if len(cert.ExtKeyUsage) == 0 {
+		return nil
+	}
+
+	for _, usage := range cert.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageServerAuth || usage == x509.ExtKeyUsageAny {
+			return nil
+		}
+	}
+
+	return errors.New("extended key usage doesn't allow server authentication")
+}
+
+func verifyIssuerUsage(cert *x509.Certificate) error {
+	if !cert.BasicConstraintsValid || !cert.IsCA {
+		return errors.New("not a CA certificate")
+	}
+
+	if cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return errors.New("key usage doesn't allow certificate signing")
+	}
+
+	return nil
+}
+
+// verifyCertificateHostname explicitly verifies that the server certificate
+// is valid for the host of rawURL, logging the result.
+func verifyCertificateHostname(
+	state *tls.ConnectionState, rawURL string, log io.Writer,
+) error {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return errors.New("response wasn't received over a TLS connection")
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse URL")
+	}
+
+	cert := state.PeerCertificates[0]
+	if err := cert.VerifyHostname(u.Hostname()); err != nil {
+		fmt.Fprintf(log, "certificate for %s isn't valid for %q: %v\n",
+			cert.Subject.CommonName, u.Hostname(), err)
+		return err
+	}
+
+	fmt.Fprintf(log, "certificate for %s is valid for %q\n",
+		cert.Subject.CommonName, u.Hostname())
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/Sydsvenskan/concourse"
+	"github.com/pkg/errors"
+)
+
+// requestError is used for non-2xx responses so that the status code is
+// available to the check error policies.
+type requestError struct {
+	err        error
+	statusCode int
+}
+
+func (e *requestError) Error() string {
+	return e.err.Error()
+}
+
+// statusError creates a error for a unexpected response status.
+func statusError(res *http.Response, format string, args ...interface{}) error {
+	return &requestError{
+		err:        errors.Errorf(format, args...),
+		statusCode: res.StatusCode,
+	}
+}
+
+// classifyCheckError classifies request errors as "5xx", "timeout",
+// "dns_failure", "cert_error" or "" for other request errors. The second
+// return value is false for errors that aren't request errors, like
+// configuration errors, which the policies don't apply to.
+func classifyCheckError(err error) (string, bool) {
+	var isRequest bool
+
+	cause := err
+	for cause != nil {
+		// Called for every step as the vendored pkg/errors doesn't
+		// support Unwrap.
+		cause = errors.Cause(cause)
+
+		switch e := cause.(type) {
+		case *requestError:
+			if e.statusCode >= 500 {
+				return "5xx", true
+			}
+			return "", true
+		case *url.Error:
+			if e.Timeout() {
+				return "timeout", true
+			}
+			isRequest = true
+			cause = e.Err
+		case *net.OpError:
+			cause = e.Err
+		case *net.DNSError:
+			return "dns_failure", true
+		case x509.UnknownAuthorityError, x509.HostnameError,
+			x509.CertificateInvalidError, x509.SystemRootsError:
+			return "cert_error", true
+		case interface{ Unwrap() error }:
+			// Newer Go versions wrap certificate errors.
+			cause = e.Unwrap()
+		default:
+			return "", isRequest
+		}
+	}
+
+	return "", isRequest
+}
+
+// validateErrorPolicies checks that the on_check_error and the error
+// class policy settings are known.
+func (s Source) validateErrorPolicies() error {
+	switch s.OnCheckError {
+	case "", "version", "fail", "return_previous":
+	default:
+		return errors.Errorf("unknown on_check_error setting %q", s.OnCheckError)
+	}
+
+	policies := map[string]string{
+		"on_5xx":         s.On5xx,
+		"on_timeout":     s.OnTimeout,
+		"on_dns_failure": s.OnDNSFailure,
+		"on_cert_error":  s.OnCertError,
+	}
+	for name, policy := range policies {
+		switch policy {
+		case "", "fail", "warn_and_skip", "return_current_version":
+		default:
+			return errors.Errorf("unknown %s setting %q", name, policy)
+		}
+	}
+
+	return nil
+}
+
+// versionsErrorStatus returns true if a non-2xx response of the URL itself
+// is versioned like any other response rather than being a check error.
+// That's the default, on_check_error "version", unless on_5xx is set for
+// 5xx responses.
+func (s Source) versionsErrorStatus(statusCode int) bool {
+	if statusCode >= 500 && s.On5xx != "" {
+		return false
+	}
+	return s.OnCheckError == "" || s.OnCheckError == "version"
+}
+
+// errorPolicy returns the policy for a class of request errors: "fail",
+// "warn_and_skip" or "return_current_version". The policy for the class
+// takes precedence over on_check_error.
+func (s Source) errorPolicy(class string) string {
+	var policy string
+	switch class {
+	case "5xx":
+		policy = s.On5xx
+	case "timeout":
+		policy = s.OnTimeout
+	case "dns_failure":
+		policy = s.OnDNSFailure
+	case "cert_error":
+		policy = s.OnCertError
+	}
+
+	if policy != "" {
+		return policy
+	}
+
+	if s.OnCheckError == "return_previous" {
+		return "return_current_version"
+	}
+
+	return "fail"
+}
+
+// handleCheckError applies the error policies to request errors in all
+// check modes, other errors are returned as is.
+func (cmd *CheckCommand) handleCheckError(
+	ctx *concourse.CommandContext, err error,
+) (*concourse.CommandResponse, error) {
+	class, ok := classifyCheckError(err)
+	if !ok {
+		return nil, err
+	}
+
+	switch cmd.Source.errorPolicy(class) {
+	case "warn_and_skip":
+		fmt.Fprintln(ctx.Log, "warning: check failed, skipping:", err.Error())
+
+		return &concourse.CommandResponse{
+			Versions: []concourse.ResourceVersion{},
+		}, nil
+	case "return_current_version":
+		fmt.Fprintln(ctx.Log, "check failed, returning the current version:", err.Error())
+
+		var resp concourse.CommandResponse
+		if cmd.Version != nil {
+			resp.Versions = append(resp.Versions, cmd.Version)
+		}
+
+		return &resp, nil
+	}
+
+	return nil, err
+}
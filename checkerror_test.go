@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckErrorStatusPolicies(t *testing.T) {
+	cases := []struct {
+		name    string
+		status  int
+		source  Source
+		err     bool
+		version bool
+	}{
+		{name: "unset versions 404", status: 404, version: true},
+		{
+			name:    "version versions 404",
+			status:  404,
+			source:  Source{OnCheckError: "version"},
+			version: true,
+		},
+		{
+			name:   "fail fails on 404",
+			status: 404,
+			source: Source{OnCheckError: "fail"},
+			err:    true,
+		},
+		{
+			name:    "on_timeout doesn't change status handling",
+			status:  404,
+			source:  Source{OnTimeout: "warn_and_skip"},
+			version: true,
+		},
+		{
+			name:   "on_5xx applies to 5xx",
+			status: 503,
+			source: Source{On5xx: "warn_and_skip"},
+		},
+		{
+			name:    "on_5xx doesn't apply to 4xx",
+			status:  404,
+			source:  Source{On5xx: "fail"},
+			version: true,
+		},
+	}
+
+	for _, c := range cases {
+		srv := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(c.status)
+			},
+		))
+
+		source := c.source
+		source.URL = srv.URL
+		source.Client = srv.Client()
+
+		cmd := CheckCommand{Source: source}
+		resp, err := cmd.HandleCommand(newTestContext(t, "check"))
+		srv.Close()
+
+		if c.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+
+		if got := len(resp.Versions) > 0; got != c.version {
+			t.Errorf("%s: got versions %v", c.name, resp.Versions)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// checksumAlgorithms maps the length of hex encoded sums to the algorithm
+// that produces them.
+var checksumAlgorithms = map[int]string{
+	32:  "md5",
+	40:  "sha1",
+	64:  "sha256",
+	128: "sha512",
+}
+
+// verifyChecksumFile fetches the checksum file and verifies the file at
+// filename against the entry for the basename of the source URL.
+func (s Source) verifyChecksumFile(
+	client *http.Client, log io.Writer, filename string,
+) error {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse source URL")
+	}
+	basename := path.Base(u.Path)
+
+	req, err := s.newRequest("GET", s.ChecksumURL, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch checksum file")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return errors.Errorf(
+			"unexpected response status %q for checksum file", res.Status)
+	}
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return errors.Wrap(err, "failed to read checksum file")
+	}
+
+	expected, err := s.findChecksum(data, basename)
+	if err != nil {
+		return err
+	}
+
+	algorithm, ok := checksumAlgorithms[len(expected)]
+	if !ok {
+		return errors.Errorf("unrecognised checksum %q", expected)
+	}
+
+	h, err := newHash(algorithm)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return errors.Wrap(err, "failed to open download")
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return errors.Wrap(err, "failed to hash download")
+	}
+
+	actual := hex.EncodeToString(h.Sum(nil))
+	if !strings.EqualFold(actual, expected) {
+		return errors.Errorf("unexpected %s checksum %q, expected %q",
+			algorithm, actual, expected)
+	}
+
+	fmt.Fprintf(log, "verified %s checksum of %s\n", algorithm, basename)
+	return nil
+}
+
+// findChecksum finds the checksum for basename in a checksum file. Lines
+// are parsed as "<hash> <filename>" unless name regexps are configured.
+func (s Source) findChecksum(data []byte, basename string) (string, error) {
+	var patterns []*regexp.Regexp
+	for _, expr := range s.ChecksumFileNameRegexp {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return "", errors.Wrapf(err,
+				"failed to compile checksum file name regexp %q", expr)
+		}
+		if re.SubexpIndex("filename") == -1 {
+			return "", errors.Errorf(
+				"checksum file name regexp %q has no filename group", expr)
+		}
+		patterns = append(patterns, re)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if len(patterns) == 0 {
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+			name := strings.TrimPrefix(fields[len(fields)-1], "*")
+			if path.Base(name) == basename {
+				return fields[0], nil
+			}
+			continue
+		}
+
+		for _, re := range patterns {
+			m := re.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			if !strings.EqualFold(m[re.SubexpIndex("filename")], basename) {
+				continue
+			}
+			if i := re.SubexpIndex("hash"); i != -1 {
+				return m[i], nil
+			}
+			if sum := firstHexField(line); sum != "" {
+				return sum, nil
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", errors.Wrap(err, "failed to read checksum file")
+	}
+
+	return "", errors.Errorf("no checksum found for %q", basename)
+}
+
+// firstHexField returns the first whitespace separated field of the line
+// that looks like a hex encoded checksum.
+func firstHexField(line string) string {
+	for _, field := range strings.Fields(line) {
+		if _, ok := checksumAlgorithms[len(field)]; !ok {
+			continue
+		}
+		if _, err := hex.DecodeString(field); err == nil {
+			return field
+		}
+	}
+	return ""
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaultMaxResponseHeaderBytes limits the size of response headers to
+// protect against servers that send excessively large headers.
+const defaultMaxResponseHeaderBytes = 1 << 20
+
+// NewHTTPClient creates a HTTP client configured according to the source
+// definition, diagnostics are written to stderr.
+func NewHTTPClient(source Source) (*http.Client, error) {
+	return newHTTPClient(source, os.Stderr)
+}
+
+// httpClient returns the injected client of the source if there is one,
+// otherwise a new client is created. The returned function releases the
+// resources of a created client and must be called once done.
+func (s Source) httpClient(log io.Writer) (*http.Client, func(), error) {
+	if s.Client != nil {
+		return s.Client, func() {}, nil
+	}
+
+	client, err := newHTTPClient(s, log)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return client, func() { closeHTTPClient(client, log) }, nil
+}
+
+// closeHTTPClient releases the resources held by the transports of the
+// client, like the request log file.
+func closeHTTPClient(client *http.Client, log io.Writer) {
+	rt := client.Transport
+	for rt != nil {
+		switch t := rt.(type) {
+		case *tokenFileTransport:
+			rt = t.Next
+		case *requestLogTransport:
+			if err := t.Close(); err != nil {
+				fmt.Fprintln(log, err.Error())
+			}
+			return
+		default:
+			return
+		}
+	}
+}
+
+// newHTTPClient creates a HTTP client configured according to the source
+// definition. Diagnostics are written to log.
+func newHTTPClient(source Source, log io.Writer) (*http.Client, error) {
+	var err error
+
+	timeout := 5 * time.Minute
+	if source.Timeout != "" {
+		timeout, err = time.ParseDuration(source.Timeout)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse timeout")
+		}
+	}
+
+	tlsConfig, err := newTLSConfig(source)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.MaxResponseHeaderBytes = defaultMaxResponseHeaderBytes
+	if source.MaxResponseHeaderBytes > 0 {
+		transport.MaxResponseHeaderBytes = source.MaxResponseHeaderBytes
+	}
+
+	if source.DialProxyURL != "" {
+		dialer, err := newProxyDialer(source)
+		if err != nil {
+			return nil, err
+		}
+
+		// All connections go through the dial proxy
+		transport.Proxy = nil
+		transport.DialContext = dialer.DialContext
+	}
+
+	client := http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+
+	// The request log wraps the transport before anything else so that
+	// the headers are logged as they're sent.
+	if source.RequestLog != "" {
+		// Headers from the source typically carry API keys
+		var redact []string
+		for name := range source.Headers {
+			redact = append(redact, name)
+		}
+
+		client.Transport, err = newRequestLogTransport(
+			source.RequestLog, redact, client.Transport,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if source.KubernetesServiceAccount {
+		u, err := url.Parse(source.URL)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse URL")
+		}
+
+		client.Transport = &tokenFileTransport{
+			File: source.kubernetesSATokenFile(),
+			Host: u.Host,
+			Next: client.Transport,
+		}
+	}
+
+	if source.AllowHTTPRedirects != nil && !*source.AllowHTTPRedirects {
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+
+			prev := via[len(via)-1]
+			if prev.URL.Scheme == "https" && req.URL.Scheme == "http" {
+				fmt.Fprintf(log, "refusing redirect from %q to %q\n",
+					prev.URL.String(), req.URL.String())
+				return errors.Errorf(
+					"redirect from https to http is not allowed: %s",
+					req.URL.String(),
+				)
+			}
+
+			return nil
+		}
+	}
+
+	return &client, nil
+}
+
+// newRequest creates a request with the source headers and credentials.
+func (s Source) newRequest(method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create request")
+	}
+
+	// Add source headers
+	for name, values := range s.Headers {
+		req.Header[name] = append(req.Header[name], values...)
+	}
+
+	if s.BasicAuth != nil {
+		req.SetBasicAuth(
+			s.BasicAuth.User,
+			s.BasicAuth.Password,
+		)
+	}
+
+	return req, nil
+}
+
+// ensureNonEmptyBody fails if the response body is empty. The body of the
+// response is replaced so that the peeked data still can be read.
+func ensureNonEmptyBody(res *http.Response) error {
+	br := bufio.NewReader(res.Body)
+
+	_, err := br.Peek(1)
+	if err == io.EOF {
+		return errors.New("the response body is empty")
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to read response contents")
+	}
+
+	res.Body = struct {
+		io.Reader
+		io.Closer
+	}{br, res.Body}
+
+	return nil
+}
+
+// download fetches url into filename, failing on non-200 responses. The
+// response, with its body closed, and the SHA1 of the contents are
+// returned.
+func (s Source) download(
+	client *http.Client, url, filename string,
+) (*http.Response, string, error) {
+	req, err := s.newRequest("GET", url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to perform request")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, "", statusError(res,
+			"unexpected response status %q for %s", res.Status, url)
+	}
+
+	output, err := os.Create(filename)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to create file for the download")
+	}
+	defer output.Close()
+
+	h := sha1.New()
+	_, err = io.Copy(io.MultiWriter(output, h), res.Body)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to write out download")
+	}
+
+	return res, fmt.Sprintf("%x", h.Sum(nil)), nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io/ioutil"
+	"mime"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// uploadContentType decides the content type of the upload: the
+// content_type param, the contents of content_type_file, a Content-Type
+// in the source headers, the type for the file extension, and finally
+// application/octet-stream.
+func (cmd *OutCommand) uploadContentType() (string, error) {
+	if cmd.Params.ContentType != "" {
+		return cmd.Params.ContentType, nil
+	}
+
+	if cmd.Params.ContentTypeFile != "" {
+		data, err := ioutil.ReadFile(cmd.Params.ContentTypeFile)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to read content type file")
+		}
+
+		contentType := strings.TrimSpace(string(data))
+		if contentType == "" {
+			return "", errors.Errorf(
+				"the content type file %q is empty", cmd.Params.ContentTypeFile)
+		}
+
+		return contentType, nil
+	}
+
+	if contentType := cmd.Source.Headers.Get("Content-Type"); contentType != "" {
+		return contentType, nil
+	}
+
+	if contentType := mime.TypeByExtension(filepath.Ext(cmd.Params.File)); contentType != "" {
+		return contentType, nil
+	}
+
+	return "application/octet-stream", nil
+}
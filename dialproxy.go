@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// proxyDialer establishes TCP connections through a HTTP CONNECT or SOCKS5
+// proxy.
+type proxyDialer struct {
+	URL      *url.URL
+	User     string
+	Password string
+	Dialer   *net.Dialer
+}
+
+func newProxyDialer(source Source) (*proxyDialer, error) {
+	u, err := url.Parse(source.DialProxyURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse dial_proxy_url")
+	}
+
+	switch u.Scheme {
+	case "http", "socks5", "socks5h":
+	default:
+		return nil, errors.Errorf(
+			"unsupported dial_proxy_url scheme %q", u.Scheme)
+	}
+
+	d := &proxyDialer{
+		URL:    u,
+		Dialer: &net.Dialer{},
+	}
+
+	if u.User != nil {
+		d.User = u.User.Username()
+		d.Password, _ = u.User.Password()
+	}
+	if source.DialProxyAuth != nil {
+		d.User = source.DialProxyAuth.User
+		d.Password = source.DialProxyAuth.Password
+	}
+
+	return d, nil
+}
+
+// DialContext connects to addr through the proxy.
+func (d *proxyDialer) DialContext(
+	ctx context.Context, network, addr string,
+) (net.Conn, error) {
+	proxyAddr := d.URL.Host
+	if d.URL.Port() == "" {
+		port := "1080"
+		if d.URL.Scheme == "http" {
+			port = "80"
+		}
+		proxyAddr = net.JoinHostPort(d.URL.Hostname(), port)
+	}
+
+	// socks5 sends a IP address, socks5h leaves name resolution to the
+	// proxy.
+	target := addr
+	if d.URL.Scheme == "socks5" {
+		var err error
+		target, err = d.resolve(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := d.Dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to dial proxy")
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	var proxied net.Conn
+	if d.URL.Scheme == "http" {
+		proxied, err = d.httpConnect(conn, addr)
+	} else {
+		proxied, err = d.socks5Connect(conn, target)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+
+	return proxied, nil
+}
+
+// resolve replaces the hostname of addr with its first IP address.
+func (d *proxyDialer) resolve(ctx context.Context, addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid address")
+	}
+	if net.ParseIP(host) != nil {
+		return addr, nil
+	}
+
+	resolver := d.Dialer.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	ips, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to resolve %s", host)
+	}
+	if len(ips) == 0 {
+		return "", errors.Errorf("no addresses found for %s", host)
+	}
+
+	return net.JoinHostPort(ips[0].IP.String(), port), nil
+}
+
+func (d *proxyDialer) httpConnect(conn net.Conn, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if d.User != "" {
+		req.Header.Set("Proxy-Authorization", "Basic "+
+			base64.StdEncoding.EncodeToString([]byte(d.User+":"+d.Password)))
+	}
+
+	if err := req.Write(conn); err != nil {
+		return nil, errors.Wrap(err, "failed to send CONNECT request")
+	}
+
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read CONNECT response")
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf(
+			"dial proxy refused CONNECT to %s: %s", addr, res.Status)
+	}
+
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+
+	return conn, nil
+}
+
+// bufferedConn is a connection with data that has already been read into a
+// buffer.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (d *proxyDialer) socks5Connect(conn net.Conn, addr string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid address")
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid port")
+	}
+
+	methods := []byte{0x00}
+	if d.User != "" {
+		methods = append(methods, 0x02)
+	}
+
+	greeting := append([]byte{0x05, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return nil, errors.Wrap(err, "failed to send SOCKS5 greeting")
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil, errors.Wrap(err, "failed to read SOCKS5 greeting")
+	}
+	if reply[0] != 0x05 {
+		return nil, errors.Errorf("unexpected SOCKS version %d", reply[0])
+	}
+
+	switch reply[1] {
+	case 0x00:
+	case 0x02:
+		if len(d.User) > 255 || len(d.Password) > 255 {
+			return nil, errors.New("SOCKS5 credentials are too long")
+		}
+
+		auth := []byte{0x01, byte(len(d.User))}
+		auth = append(auth, d.User...)
+		auth = append(auth, byte(len(d.Password)))
+		auth = append(auth, d.Password...)
+		if _, err := conn.Write(auth); err != nil {
+			return nil, errors.Wrap(err, "failed to send SOCKS5 credentials")
+		}
+
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return nil, errors.Wrap(err, "failed to read SOCKS5 auth reply")
+		}
+		if reply[1] != 0x00 {
+			return nil, errors.New("dial proxy rejected the SOCKS5 credentials")
+		}
+	default:
+		return nil, errors.New("dial proxy has no acceptable SOCKS5 auth method")
+	}
+
+	req := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, 0x01)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, 0x04)
+			req = append(req, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, errors.New("hostname is too long for SOCKS5")
+		}
+		req = append(req, 0x03, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+
+	if _, err := conn.Write(req); err != nil {
+		return nil, errors.Wrap(err, "failed to send SOCKS5 connect request")
+	}
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return nil, errors.Wrap(err, "failed to read SOCKS5 connect reply")
+	}
+	if head[1] != 0x00 {
+		return nil, errors.Errorf(
+			"dial proxy failed to connect to %s: SOCKS5 reply %d", addr, head[1])
+	}
+
+	var addrLen int
+	switch head[3] {
+	case 0x01:
+		addrLen = net.IPv4len
+	case 0x04:
+		addrLen = net.IPv6len
+	case 0x03:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return nil, errors.Wrap(err, "failed to read SOCKS5 connect reply")
+		}
+		addrLen = int(l[0])
+	default:
+		return nil, errors.Errorf("unknown SOCKS5 address type %d", head[3])
+	}
+
+	// Discard the bound address and port
+	bound := make([]byte, addrLen+2)
+	if _, err := io.ReadFull(conn, bound); err != nil {
+		return nil, errors.Wrap(err, "failed to read SOCKS5 connect reply")
+	}
+
+	return conn, nil
+}
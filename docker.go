@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/Sydsvenskan/concourse"
+	"github.com/pkg/errors"
+)
+
+const dockerManifestV2 = "application/vnd.docker.distribution.manifest.v2+json"
+
+// DockerRegistry configures version tracking of a image in a Docker
+// registry, source.url is used as the registry base URL.
+type DockerRegistry struct {
+	Repository string `json:"repository"`
+	// Reference is the tag or digest to track, defaults to "latest".
+	Reference string `json:"reference"`
+}
+
+type dockerManifest struct {
+	Config struct {
+		Digest string `json:"digest"`
+	} `json:"config"`
+}
+
+// fetchDockerManifest fetches the image manifest, authenticating with a
+// bearer token if the registry asks for it.
+func (s Source) fetchDockerManifest(client *http.Client) ([]byte, string, error) {
+	reg := s.DockerRegistry
+
+	reference := reg.Reference
+	if reference == "" {
+		reference = "latest"
+	}
+
+	manifestURL := strings.TrimSuffix(s.URL, "/") +
+		"/v2/" + reg.Repository + "/manifests/" + reference
+
+	var token string
+	for attempt := 0; attempt < 2; attempt++ {
+		req, err := s.newRequest("GET", manifestURL, nil)
+		if err != nil {
+			return nil, "", err
+		}
+		req.Header.Set("Accept", dockerManifestV2)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "failed to fetch manifest")
+		}
+
+		data, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return nil, "", errors.Wrap(err, "failed to read manifest")
+		}
+
+		challenge := res.Header.Get("WWW-Authenticate")
+		if res.StatusCode == http.StatusUnauthorized && token == "" &&
+			strings.HasPrefix(challenge, "Bearer ") {
+			token, err = s.dockerToken(client, challenge)
+			if err != nil {
+				return nil, "", err
+			}
+			continue
+		}
+
+		if res.StatusCode != http.StatusOK {
+			return nil, "", statusError(res,
+				"unexpected response status %q for manifest", res.Status)
+		}
+
+		return data, res.Header.Get("Content-Type"), nil
+	}
+
+	return nil, "", errors.New("registry rejected the bearer token")
+}
+
+// dockerToken performs the Docker token authentication flow for a
+// WWW-Authenticate bearer challenge.
+func (s Source) dockerToken(client *http.Client, challenge string) (string, error) {
+	params := parseAuthChallenge(strings.TrimPrefix(challenge, "Bearer "))
+
+	realm := params["realm"]
+	if realm == "" {
+		return "", errors.New("bearer challenge is missing a realm")
+	}
+
+	query := url.Values{}
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	if params["scope"] != "" {
+		query.Set("scope", params["scope"])
+	}
+
+	tokenURL := realm
+	if len(query) > 0 {
+		tokenURL += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest("GET", tokenURL, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create token request")
+	}
+	if s.BasicAuth != nil {
+		req.SetBasicAuth(s.BasicAuth.User, s.BasicAuth.Password)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to fetch registry token")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", statusError(res,
+			"unexpected response status %q for registry token", res.Status)
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", errors.Wrap(err, "failed to decode registry token")
+	}
+
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	return body.AccessToken, nil
+}
+
+// parseAuthChallenge parses the comma separated key="value" parameters of
+// a WWW-Authenticate challenge.
+func parseAuthChallenge(challenge string) map[string]string {
+	params := make(map[string]string)
+
+	for challenge != "" {
+		eq := strings.Index(challenge, "=")
+		if eq == -1 {
+			break
+		}
+		key := strings.TrimSpace(challenge[:eq])
+		challenge = challenge[eq+1:]
+
+		var value string
+		if strings.HasPrefix(challenge, `"`) {
+			end := strings.Index(challenge[1:], `"`)
+			if end == -1 {
+				value, challenge = challenge[1:], ""
+			} else {
+				value, challenge = challenge[1:end+1], challenge[end+2:]
+			}
+		} else {
+			end := strings.Index(challenge, ",")
+			if end == -1 {
+				end = len(challenge)
+			}
+			value, challenge = challenge[:end], challenge[end:]
+		}
+		params[key] = value
+
+		challenge = strings.TrimPrefix(strings.TrimSpace(challenge), ",")
+	}
+
+	return params
+}
+
+func manifestDigest(data []byte) (string, error) {
+	var manifest dockerManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return "", errors.Wrap(err, "failed to decode manifest")
+	}
+	if manifest.Config.Digest == "" {
+		return "", errors.New("manifest doesn't have a config digest")
+	}
+	return manifest.Config.Digest, nil
+}
+
+func (cmd *CheckCommand) checkDockerRegistry(client *http.Client) (
+	*concourse.CommandResponse, error,
+) {
+	var resp concourse.CommandResponse
+
+	if cmd.Version != nil {
+		resp.Versions = append(resp.Versions, cmd.Version)
+	}
+
+	data, _, err := cmd.Source.fetchDockerManifest(client)
+	if err != nil {
+		return nil, err
+	}
+
+	digest, err := manifestDigest(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if digest == cmd.Version["digest"] {
+		return &resp, nil
+	}
+
+	resp.Versions = []concourse.ResourceVersion{
+		{"digest": digest},
+	}
+
+	return &resp, nil
+}
+
+func (cmd *InCommand) inDockerRegistry(
+	ctx *concourse.CommandContext, client *http.Client,
+) (*concourse.CommandResponse, error) {
+	var resp concourse.CommandResponse
+
+	data, contentType, err := cmd.Source.fetchDockerManifest(client)
+	if err != nil {
+		return nil, err
+	}
+
+	digest, err := manifestDigest(data)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := cmd.Version["digest"]
+	if expected != "" && digest != expected {
+		return nil, errors.Errorf(
+			"unexpected config digest %q, expected %q", digest, expected,
+		)
+	}
+
+	err = ioutil.WriteFile(
+		filepath.Join(ctx.Directory(), "manifest.json"), data, 0666,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to write manifest")
+	}
+
+	resp.Version = concourse.ResourceVersion{"digest": digest}
+	resp.AddMeta("content-type", contentType)
+
+	return &resp, nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Sydsvenskan/concourse"
+	"github.com/pkg/errors"
+)
+
+const defaultGitHubAPIBase = "https://api.github.com"
+
+// validateGitHubAPIBase checks that the GitHub API base is a absolute
+// http(s) URL.
+func validateGitHubAPIBase(base string) error {
+	u, err := url.Parse(base)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse github_api_base")
+	}
+
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return errors.Errorf(
+			"github_api_base must be a http or https URL, got %q", base)
+	}
+	if u.Host == "" {
+		return errors.Errorf("github_api_base must include a host, got %q", base)
+	}
+
+	return nil
+}
+
+// githubAPIBase returns the configured GitHub API base URL without a
+// trailing slash.
+func (s Source) githubAPIBase() string {
+	if s.GitHubAPIBase == "" {
+		return defaultGitHubAPIBase
+	}
+	return strings.TrimSuffix(s.GitHubAPIBase, "/")
+}
+
+type githubPackageVersion struct {
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	CreatedAt string `json:"created_at"`
+	HTMLURL   string `json:"html_url"`
+	Metadata  struct {
+		Container struct {
+			Tags []string `json:"tags"`
+		} `json:"container"`
+	} `json:"metadata"`
+}
+
+// githubPackageVersionsURL is the URL of the versions of the package.
+func (s Source) githubPackageVersionsURL() (string, error) {
+	if s.Owner == "" || s.PackageName == "" || s.PackageType == "" {
+		return "", errors.New(
+			"owner, package_name and package_type are required in github-packages mode")
+	}
+
+	return fmt.Sprintf("%s/users/%s/packages/%s/%s/versions",
+		s.githubAPIBase(),
+		url.PathEscape(s.Owner),
+		url.PathEscape(s.PackageType),
+		url.PathEscape(s.PackageName),
+	), nil
+}
+
+// githubRequest performs a authenticated GitHub API request and returns
+// the response body.
+func (s Source) githubRequest(client *http.Client, apiURL string) ([]byte, error) {
+	req, err := s.newRequest("GET", apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to perform request")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, statusError(res,
+			"unexpected response status %q for %s", res.Status, apiURL)
+	}
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read response contents")
+	}
+
+	return data, nil
+}
+
+// fetchGitHubPackageVersions lists all versions of the package, oldest
+// first.
+func (s Source) fetchGitHubPackageVersions(client *http.Client) (
+	[]githubPackageVersion, error,
+) {
+	versionsURL, err := s.githubPackageVersionsURL()
+	if err != nil {
+		return nil, err
+	}
+
+	const perPage = 100
+
+	var versions []githubPackageVersion
+	for page := 1; ; page++ {
+		pageURL, err := withQuery(versionsURL, url.Values{
+			"per_page": {strconv.Itoa(perPage)},
+			"page":     {strconv.Itoa(page)},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := s.githubRequest(client, pageURL)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list package versions")
+		}
+
+		var batch []githubPackageVersion
+		if err := json.Unmarshal(data, &batch); err != nil {
+			return nil, errors.Wrap(err, "failed to decode package versions")
+		}
+
+		versions = append(versions, batch...)
+
+		if len(batch) < perPage {
+			break
+		}
+	}
+
+	// Version IDs are assigned in increasing order.
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].ID < versions[j].ID
+	})
+
+	return versions, nil
+}
+
+// checkGitHubPackages returns the package versions that are newer than
+// the current version, or all of them if there is no current version.
+func (cmd *CheckCommand) checkGitHubPackages(client *http.Client) (
+	*concourse.CommandResponse, error,
+) {
+	var resp concourse.CommandResponse
+
+	if cmd.Version != nil {
+		resp.Versions = append(resp.Versions, cmd.Version)
+	}
+
+	var currentID int64
+	if current := cmd.Version["package_version_id"]; current != "" {
+		id, err := strconv.ParseInt(current, 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse current package version ID")
+		}
+		currentID = id
+	}
+
+	versions, err := cmd.Source.fetchGitHubPackageVersions(client)
+	if err != nil {
+		return nil, err
+	}
+
+	var newer []concourse.ResourceVersion
+	for _, v := range versions {
+		if v.ID <= currentID {
+			continue
+		}
+
+		newer = append(newer, concourse.ResourceVersion{
+			"package_version_id": strconv.FormatInt(v.ID, 10),
+			"name":               v.Name,
+		})
+	}
+
+	if len(newer) == 0 {
+		return &resp, nil
+	}
+
+	resp.Versions = newer
+
+	return &resp, nil
+}
+
+// inGitHubPackages writes the metadata of the package version to
+// version.json, the package itself is left to the package manager.
+func (cmd *InCommand) inGitHubPackages(
+	ctx *concourse.CommandContext, client *http.Client,
+) (*concourse.CommandResponse, error) {
+	var resp concourse.CommandResponse
+
+	id := cmd.Version["package_version_id"]
+	if id == "" {
+		return nil, errors.New("no package version to fetch")
+	}
+
+	versionsURL, err := cmd.Source.githubPackageVersionsURL()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := cmd.Source.githubRequest(client, versionsURL+"/"+url.PathEscape(id))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch package version")
+	}
+
+	var version githubPackageVersion
+	if err := json.Unmarshal(data, &version); err != nil {
+		return nil, errors.Wrap(err, "failed to decode package version")
+	}
+
+	err = ioutil.WriteFile(filepath.Join(ctx.Directory(), "version.json"), data, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to write package version metadata")
+	}
+
+	resp.Version = concourse.ResourceVersion{
+		"package_version_id": id,
+		"name":               version.Name,
+	}
+	resp.AddMeta("created-at", version.CreatedAt)
+	resp.AddMeta("url", version.HTMLURL)
+	if tags := version.Metadata.Container.Tags; len(tags) > 0 {
+		resp.AddMeta("tags", strings.Join(tags, ","))
+	}
+
+	return &resp, nil
+}
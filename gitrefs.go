@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/Sydsvenskan/concourse"
+	"github.com/pkg/errors"
+)
+
+type gitRef struct {
+	Ref string
+	SHA string
+}
+
+// parseGitRefs parses a info/refs or packed-refs listing, comments and
+// peeled tag lines are skipped.
+func parseGitRefs(data []byte) []gitRef {
+	var refs []gitRef
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == '^' {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		refs = append(refs, gitRef{Ref: fields[1], SHA: fields[0]})
+	}
+
+	return refs
+}
+
+// fetchGitRefs fetches the refs listing and returns the refs matching the
+// configured regexp.
+func (s Source) fetchGitRefs(client *http.Client) ([]gitRef, error) {
+	var filter *regexp.Regexp
+	if s.RefRegexp != "" {
+		var err error
+		filter, err = regexp.Compile(s.RefRegexp)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to compile ref regexp")
+		}
+	}
+
+	req, err := s.newRequest("GET", s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch refs")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, statusError(res,
+			"unexpected response status %q for refs", res.Status)
+	}
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read refs")
+	}
+
+	var refs []gitRef
+	for _, ref := range parseGitRefs(data) {
+		if filter == nil || filter.MatchString(ref.Ref) {
+			refs = append(refs, ref)
+		}
+	}
+
+	return refs, nil
+}
+
+// checkGitRefs emits all the matching refs on every check, as refs have no
+// chronology and new ones can appear anywhere in the listing. The current
+// version comes first if it's still listed, followed by the other refs in
+// natural order, so that "v1.10" sorts after "v1.9". Concourse skips the
+// versions it has already seen.
+func (cmd *CheckCommand) checkGitRefs(client *http.Client) (
+	*concourse.CommandResponse, error,
+) {
+	var resp concourse.CommandResponse
+
+	refs, err := cmd.Source.fetchGitRefs(client)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(refs, func(i, j int) bool {
+		return compareNatural(refs[i].Ref, refs[j].Ref) < 0
+	})
+
+	resp.Versions = []concourse.ResourceVersion{}
+	for _, ref := range refs {
+		if ref.Ref == cmd.Version["ref"] && ref.SHA == cmd.Version["sha"] {
+			resp.Versions = append(
+				[]concourse.ResourceVersion{cmd.Version}, resp.Versions...)
+			continue
+		}
+
+		resp.Versions = append(resp.Versions, concourse.ResourceVersion{
+			"ref": ref.Ref,
+			"sha": ref.SHA,
+		})
+	}
+
+	return &resp, nil
+}
+
+// compareNatural compares strings with runs of digits compared by their
+// numeric value.
+func compareNatural(a, b string) int {
+	for a != "" && b != "" {
+		ad, bd := leadingDigits(a), leadingDigits(b)
+		if ad != "" && bd != "" {
+			an := strings.TrimLeft(ad, "0")
+			bn := strings.TrimLeft(bd, "0")
+			if len(an) != len(bn) {
+				return compareInt(len(an), len(bn))
+			}
+			if c := strings.Compare(an, bn); c != 0 {
+				return c
+			}
+			a, b = a[len(ad):], b[len(bd):]
+			continue
+		}
+
+		if a[0] != b[0] {
+			return compareInt(int(a[0]), int(b[0]))
+		}
+		a, b = a[1:], b[1:]
+	}
+
+	return compareInt(len(a), len(b))
+}
+
+func leadingDigits(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func (cmd *InCommand) inGitRefs(
+	ctx *concourse.CommandContext, client *http.Client,
+) (*concourse.CommandResponse, error) {
+	var resp concourse.CommandResponse
+
+	if cmd.Source.DownloadURLTemplate == "" {
+		return nil, errors.New("git-refs mode requires download_url_template")
+	}
+
+	tmpl, err := template.New("url").Parse(cmd.Source.DownloadURLTemplate)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse download URL template")
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, gitRef{
+		Ref: cmd.Version["ref"],
+		SHA: cmd.Version["sha"],
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to expand download URL template")
+	}
+	downloadURL := buf.String()
+
+	_, sum, err := cmd.Source.download(client, downloadURL,
+		filepath.Join(ctx.Directory(), "downloaded"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Version = concourse.ResourceVersion{
+		"ref": cmd.Version["ref"],
+		"sha": cmd.Version["sha"],
+	}
+	resp.AddMeta("url", downloadURL)
+	resp.AddMeta("sha1", sum)
+
+	return &resp, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/Sydsvenskan/concourse"
+)
+
+func TestCheckGitRefs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "aaa\trefs/tags/v1.10.0\n"+
+				"bbb\trefs/tags/v1.2.0\n"+
+				"ccc\trefs/tags/v1.9.0\n"+
+				"ddd\trefs/heads/main\n")
+		},
+	))
+	defer srv.Close()
+
+	source := Source{
+		URL:       srv.URL,
+		Mode:      "git-refs",
+		RefRegexp: "^refs/tags/",
+		Client:    srv.Client(),
+	}
+
+	cases := []struct {
+		name    string
+		current concourse.ResourceVersion
+		want    []string
+	}{
+		{
+			name: "first check",
+			want: []string{"v1.2.0", "v1.9.0", "v1.10.0"},
+		},
+		{
+			name:    "current version first",
+			current: concourse.ResourceVersion{"ref": "refs/tags/v1.9.0", "sha": "ccc"},
+			want:    []string{"v1.9.0", "v1.2.0", "v1.10.0"},
+		},
+		{
+			name:    "moved current ref",
+			current: concourse.ResourceVersion{"ref": "refs/tags/v1.9.0", "sha": "old"},
+			want:    []string{"v1.2.0", "v1.9.0", "v1.10.0"},
+		},
+	}
+
+	for _, c := range cases {
+		cmd := CheckCommand{Source: source, Version: c.current}
+		resp, err := cmd.HandleCommand(newTestContext(t, "check"))
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+
+		var got []string
+		for _, v := range resp.Versions {
+			got = append(got, v["ref"][len("refs/tags/"):])
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, expected %v", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// standardHashes are the algorithms computed with compute_all_hashes.
+var standardHashes = []string{"md5", "sha1", "sha256", "sha512"}
+
+// newHash creates a hash for a named algorithm.
+func newHash(name string) (hash.Hash, error) {
+	switch name {
+	case "md5":
+		return md5.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	}
+	return nil, errors.Errorf("unknown hash algorithm %q", name)
+}
+
+// newHashes creates hashes for the named algorithms.
+func newHashes(names []string) (map[string]hash.Hash, error) {
+	hashes := make(map[string]hash.Hash, len(names))
+	for _, name := range names {
+		h, err := newHash(name)
+		if err != nil {
+			return nil, err
+		}
+		hashes[name] = h
+	}
+	return hashes, nil
+}
+
+// writeHashFiles writes the hex encoded sums of the hashes to
+// <name>.<algorithm> files in dir, and all of them to checksums.json.
+func writeHashFiles(dir, name string, hashes map[string]hash.Hash) (map[string]string, error) {
+	sums := make(map[string]string, len(hashes))
+	for algorithm, h := range hashes {
+		sums[algorithm] = fmt.Sprintf("%x", h.Sum(nil))
+
+		filename := filepath.Join(dir, name+"."+algorithm)
+		err := ioutil.WriteFile(filename, []byte(sums[algorithm]+"\n"), 0644)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to write %s file", algorithm)
+		}
+	}
+
+	data, err := json.MarshalIndent(sums, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode checksums")
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "checksums.json"), data, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to write checksums.json")
+	}
+
+	return sums, nil
+}
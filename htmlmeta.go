@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// htmlMetaVersion extracts the content of the first <meta> tag with the
+// configured name or property.
+func (s Source) htmlMetaVersion(data []byte) (string, error) {
+	tags, err := htmlMetaTags(data)
+	if err != nil {
+		return "", err
+	}
+
+	for _, attrs := range tags {
+		if (s.HTMLMetaName != "" && attrs["name"] == s.HTMLMetaName) ||
+			(s.HTMLMetaProperty != "" && attrs["property"] == s.HTMLMetaProperty) {
+			return attrs["content"], nil
+		}
+	}
+
+	return "", errors.New("no matching <meta> tag found")
+}
+
+// htmlMetaTags tokenizes a HTML document and returns the attributes of its
+// <meta> tags. The tokenizer skips comments and the contents of <script>
+// and other raw text elements, and unescapes the attribute values.
+func htmlMetaTags(data []byte) ([]map[string]string, error) {
+	var tags []map[string]string
+
+	z := html.NewTokenizer(bytes.NewReader(data))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				return tags, nil
+			}
+			return nil, errors.Wrap(z.Err(), "failed to tokenize HTML")
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := z.Token()
+			if token.DataAtom != atom.Meta {
+				continue
+			}
+
+			attrs := make(map[string]string)
+			for _, attr := range token.Attr {
+				// The first occurrence of an attribute wins, the
+				// tokenizer has already lowercased the names.
+				if _, exists := attrs[attr.Key]; !exists {
+					attrs[attr.Key] = attr.Val
+				}
+			}
+			tags = append(tags, attrs)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestHTMLMetaVersion(t *testing.T) {
+	cases := []struct {
+		name string
+		doc  string
+		want string
+		err  bool
+	}{
+		{
+			name: "name attribute",
+			doc:  `<html><head><meta name="version" content="1.2.3"></head></html>`,
+			want: "1.2.3",
+		},
+		{
+			name: "entities are decoded",
+			doc:  `<meta NAME=version content="1.2.3&amp;beta">`,
+			want: "1.2.3&beta",
+		},
+		{
+			name: "comments are skipped",
+			doc: `<!-- <meta name="version" content="0.1"> -->` +
+				`<meta name="version" content="2.0">`,
+			want: "2.0",
+		},
+		{
+			name: "script contents are skipped",
+			doc: `<script>var s = '<meta name="version" content="0.1">';</script>` +
+				`<meta name="version" content="3.0">`,
+			want: "3.0",
+		},
+		{
+			name: "attribute values are skipped",
+			doc: `<div title='<meta name="version" content="0.1">'></div>` +
+				`<meta name="version" content="4.0"/>`,
+			want: "4.0",
+		},
+		{
+			name: "no match",
+			doc:  `<meta name="other" content="1.0">`,
+			err:  true,
+		},
+	}
+
+	source := Source{HTMLMetaName: "version"}
+	for _, c := range cases {
+		got, err := source.htmlMetaVersion([]byte(c.doc))
+		if c.err {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %q", c.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%s: got %q, expected %q", c.name, got, c.want)
+		}
+	}
+}
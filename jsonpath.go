@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// jsonPathStep is a single step in a JSON path, either an object key or an
+// array index.
+type jsonPathStep struct {
+	Key   string
+	Index int
+	IsKey bool
+}
+
+// parseJSONPath parses the subset of JSONPath that's supported: "$"
+// followed by ".key", "['key']" and "[index]" steps.
+func parseJSONPath(path string) ([]jsonPathStep, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, errors.Errorf("JSON path %q must start with $", path)
+	}
+
+	var steps []jsonPathStep
+	rest := path[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, errors.Errorf("empty key in JSON path %q", path)
+			}
+			steps = append(steps, jsonPathStep{Key: rest[:end], IsKey: true})
+			rest = rest[end:]
+		case '[':
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return nil, errors.Errorf("unterminated [ in JSON path %q", path)
+			}
+			inner := rest[1:end]
+			rest = rest[end+1:]
+
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') &&
+				inner[len(inner)-1] == inner[0] {
+				steps = append(steps, jsonPathStep{
+					Key: inner[1 : len(inner)-1], IsKey: true,
+				})
+				continue
+			}
+
+			index, err := strconv.Atoi(inner)
+			if err != nil {
+				return nil, errors.Errorf(
+					"invalid index %q in JSON path %q", inner, path)
+			}
+			steps = append(steps, jsonPathStep{Index: index})
+		default:
+			return nil, errors.Errorf(
+				"unexpected %q in JSON path %q", rest[0], path)
+		}
+	}
+
+	return steps, nil
+}
+
+// evalJSONPath evaluates a JSON path against a decoded JSON document. The
+// second return value is false if the path didn't match anything.
+func evalJSONPath(doc interface{}, path string) (interface{}, bool, error) {
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	value, ok := evalJSONSteps(doc, steps)
+	return value, ok, nil
+}
+
+func evalJSONSteps(doc interface{}, steps []jsonPathStep) (interface{}, bool) {
+	current := doc
+	for _, step := range steps {
+		if step.IsKey {
+			obj, ok := current.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			current, ok = obj[step.Key]
+			if !ok {
+				return nil, false
+			}
+			continue
+		}
+
+		arr, ok := current.([]interface{})
+		if !ok {
+			return nil, false
+		}
+		index := step.Index
+		if index < 0 {
+			index += len(arr)
+		}
+		if index < 0 || index >= len(arr) {
+			return nil, false
+		}
+		current = arr[index]
+	}
+
+	return current, true
+}
+
+// setJSONPath replaces the value at a JSON path in a decoded JSON
+// document, nothing is done if the path doesn't match anything.
+func setJSONPath(doc interface{}, path string, value interface{}) error {
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return err
+	}
+	if len(steps) == 0 {
+		return errors.Errorf("can't replace the document root")
+	}
+
+	parent, ok := evalJSONSteps(doc, steps[:len(steps)-1])
+	if !ok {
+		return nil
+	}
+
+	last := steps[len(steps)-1]
+	if last.IsKey {
+		obj, ok := parent.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		if _, exists := obj[last.Key]; exists {
+			obj[last.Key] = value
+		}
+		return nil
+	}
+
+	arr, ok := parent.([]interface{})
+	if !ok {
+		return nil
+	}
+	index := last.Index
+	if index < 0 {
+		index += len(arr)
+	}
+	if index >= 0 && index < len(arr) {
+		arr[index] = value
+	}
+
+	return nil
+}
+
+// redactJSONFile replaces the values at the JSON paths in a JSON file with
+// "[REDACTED]".
+func redactJSONFile(filename string, paths []string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return errors.Wrap(err, "failed to read file")
+	}
+
+	doc, err := decodeJSON(data)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		if err := setJSONPath(doc, path, "[REDACTED]"); err != nil {
+			return err
+		}
+	}
+
+	data, err = json.Marshal(doc)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode JSON")
+	}
+
+	return errors.Wrap(
+		ioutil.WriteFile(filename, data, 0666),
+		"failed to write file",
+	)
+}
+
+// jsonValueString returns the string representation of a decoded JSON
+// value.
+func jsonValueString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return fmt.Sprintf("%v", v), nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode JSON value")
+	}
+	return string(data), nil
+}
+
+// decodeJSON decodes JSON data keeping numbers as json.Number.
+func decodeJSON(data []byte) (interface{}, error) {
+	var doc interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, errors.Wrap(err, "failed to decode JSON")
+	}
+
+	return doc, nil
+}
+
+// jsonPathVersion extracts a version from a JSON document using the
+// configured version JSON path and fallback.
+func (s Source) jsonPathVersion(data []byte) (string, error) {
+	doc, err := decodeJSON(data)
+	if err != nil {
+		return "", err
+	}
+
+	value, ok, err := evalJSONPath(doc, s.VersionJSONPath)
+	if err != nil {
+		return "", err
+	}
+	if ok && value != nil {
+		return s.jsonVersionString(value)
+	}
+
+	fallback := s.VersionJSONPathFallback
+	switch {
+	case fallback == "":
+		return "", errors.Errorf(
+			"no version found at %q", s.VersionJSONPath)
+	case !strings.HasPrefix(fallback, "$"):
+		return fallback, nil
+	}
+
+	value, ok, err = evalJSONPath(doc, fallback)
+	if err != nil {
+		return "", err
+	}
+	if !ok || value == nil {
+		return "", errors.Errorf(
+			"no version found at %q or %q", s.VersionJSONPath, fallback)
+	}
+
+	return s.jsonVersionString(value)
+}
+
+// jsonVersionString converts a JSON value to a version, arrays are handled
+// according to the configured array strategy.
+func (s Source) jsonVersionString(value interface{}) (string, error) {
+	arr, ok := value.([]interface{})
+	if !ok {
+		return jsonValueString(value)
+	}
+
+	switch s.JSONPathArrayStrategy {
+	case "", "first":
+		if len(arr) == 0 {
+			return "", errors.New("the version array is empty")
+		}
+		return jsonValueString(arr[0])
+	case "last":
+		if len(arr) == 0 {
+			return "", errors.New("the version array is empty")
+		}
+		return jsonValueString(arr[len(arr)-1])
+	case "join":
+		parts := make([]string, len(arr))
+		for i := range arr {
+			part, err := jsonValueString(arr[i])
+			if err != nil {
+				return "", err
+			}
+			parts[i] = part
+		}
+		return strings.Join(parts, ","), nil
+	case "count":
+		return strconv.Itoa(len(arr)), nil
+	case "hash":
+		data, err := json.Marshal(arr)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to encode JSON value")
+		}
+		return fmt.Sprintf("%x", sha256.Sum256(data)), nil
+	}
+
+	return "", errors.Errorf(
+		"unknown JSON path array strategy %q", s.JSONPathArrayStrategy)
+}
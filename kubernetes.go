@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultKubernetesSATokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	defaultKubernetesSACAFile    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+func (s Source) kubernetesSATokenFile() string {
+	if s.KubernetesSATokenFile != "" {
+		return s.KubernetesSATokenFile
+	}
+	return defaultKubernetesSATokenFile
+}
+
+func (s Source) kubernetesSACAFile() string {
+	if s.KubernetesSACAFile != "" {
+		return s.KubernetesSACAFile
+	}
+	return defaultKubernetesSACAFile
+}
+
+// tokenFileTransport authenticates requests with a bearer token that's read
+// from a file for every request, so that rotated tokens are picked up. The
+// token is only sent to Host, and never replaces a Authorization header
+// that's already set.
+type tokenFileTransport struct {
+	File string
+	Host string
+	Next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *tokenFileTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.Host == "" || req.URL.Host != t.Host || req.Header.Get("Authorization") != "" {
+		return t.Next.RoundTrip(req)
+	}
+
+	token, err := ioutil.ReadFile(t.File)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read service account token")
+	}
+
+	// Round trippers must not modify the original request
+	r := req.Clone(req.Context())
+	r.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+
+	return t.Next.RoundTrip(r)
+}
+
+// systemCertPoolWith returns the system cert pool with the certificates in
+// the PEM file added.
+func systemCertPoolWith(file string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read CA file %q", file)
+	}
+
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.Errorf("no certificates found in %q", file)
+	}
+
+	return pool, nil
+}
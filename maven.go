@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/Sydsvenskan/concourse"
+	"github.com/pkg/errors"
+)
+
+type mavenMetadata struct {
+	GroupID    string `xml:"groupId"`
+	ArtifactID string `xml:"artifactId"`
+	Versioning struct {
+		Latest   string   `xml:"latest"`
+		Versions []string `xml:"versions>version"`
+	} `xml:"versioning"`
+}
+
+func (s Source) fetchMavenMetadata(client *http.Client) (*mavenMetadata, error) {
+	req, err := s.newRequest("GET", s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch maven metadata")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, statusError(res,
+			"unexpected response status %q for maven metadata", res.Status)
+	}
+
+	var metadata mavenMetadata
+	if err := xml.NewDecoder(res.Body).Decode(&metadata); err != nil {
+		return nil, errors.Wrap(err, "failed to decode maven metadata")
+	}
+
+	if len(metadata.Versioning.Versions) == 0 && metadata.Versioning.Latest != "" {
+		metadata.Versioning.Versions = []string{metadata.Versioning.Latest}
+	}
+
+	return &metadata, nil
+}
+
+// mavenArtifactURL replaces the metadata filename in the source URL with
+// the path of the artifact for the version.
+func (s Source) mavenArtifactURL(artifactID, version string) string {
+	packaging := s.MavenPackaging
+	if packaging == "" {
+		packaging = "jar"
+	}
+
+	base := s.URL[:strings.LastIndex(s.URL, "/")+1]
+	return base + version + "/" + artifactID + "-" + version + "." + packaging
+}
+
+// checkMavenMetadata returns the versions listed after the current version
+// in the metadata, or all of them if there is no current version.
+func (cmd *CheckCommand) checkMavenMetadata(client *http.Client) (
+	*concourse.CommandResponse, error,
+) {
+	var resp concourse.CommandResponse
+
+	if cmd.Version != nil {
+		resp.Versions = append(resp.Versions, cmd.Version)
+	}
+
+	metadata, err := cmd.Source.fetchMavenMetadata(client)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := metadata.Versioning.Versions
+	if current := cmd.Version["version"]; current != "" {
+		for i, v := range versions {
+			if v == current {
+				versions = versions[i+1:]
+				break
+			}
+		}
+	}
+
+	if len(versions) == 0 {
+		return &resp, nil
+	}
+
+	resp.Versions = nil
+	for _, v := range versions {
+		resp.Versions = append(resp.Versions, concourse.ResourceVersion{
+			"version": v,
+		})
+	}
+
+	return &resp, nil
+}
+
+func (cmd *InCommand) inMavenMetadata(
+	ctx *concourse.CommandContext, client *http.Client,
+) (*concourse.CommandResponse, error) {
+	var resp concourse.CommandResponse
+
+	version := cmd.Version["version"]
+	if version == "" {
+		return nil, errors.New("no version to fetch")
+	}
+
+	metadata, err := cmd.Source.fetchMavenMetadata(client)
+	if err != nil {
+		return nil, err
+	}
+
+	artifactURL := cmd.Source.mavenArtifactURL(metadata.ArtifactID, version)
+
+	_, sum, err := cmd.Source.download(client, artifactURL,
+		filepath.Join(ctx.Directory(), "downloaded"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Version = concourse.ResourceVersion{"version": version}
+	resp.AddMeta("group-id", metadata.GroupID)
+	resp.AddMeta("artifact-id", metadata.ArtifactID)
+	resp.AddMeta("url", artifactURL)
+	resp.AddMeta("sha1", sum)
+
+	return &resp, nil
+}
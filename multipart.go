@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/Sydsvenskan/concourse"
+	"github.com/pkg/errors"
+)
+
+// defaultMultipartPartSize is a multiple of 256KiB (required by GCS) and
+// above the 5MiB minimum part size of S3.
+const defaultMultipartPartSize = 8 << 20
+
+type s3InitiateResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type s3CompleteUpload struct {
+	XMLName xml.Name `xml:"CompleteMultipartUpload"`
+	Parts   []s3Part `xml:"Part"`
+}
+
+type s3Part struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// s3CompleteResult is used to decode the response to a complete request,
+// which can be a error even if the response status is 200.
+type s3CompleteResult struct {
+	XMLName xml.Name
+	ETag    string `xml:"ETag"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+type azureBlockList struct {
+	XMLName xml.Name `xml:"BlockList"`
+	Latest  []string `xml:"Latest"`
+}
+
+// uploadMultipart uploads the file in parts with the configured multipart
+// protocol and returns the ETag of the uploaded resource.
+func (cmd *OutCommand) uploadMultipart(
+	ctx *concourse.CommandContext, client *http.Client,
+	file io.ReaderAt, size int64,
+) (string, error) {
+	partSize := cmd.Params.ChunkSize
+	if partSize <= 0 {
+		partSize = defaultMultipartPartSize
+	}
+
+	switch cmd.Params.MultipartProtocol {
+	case "", "s3":
+		return cmd.uploadS3Multipart(ctx, client, file, size, partSize)
+	case "azure":
+		return cmd.uploadAzureBlocks(ctx, client, file, size, partSize)
+	case "gcs":
+		return cmd.uploadGCSResumable(ctx, client, file, size, partSize)
+	}
+
+	return "", errors.Errorf(
+		"unknown multipart protocol %q", cmd.Params.MultipartProtocol)
+}
+
+// uploadS3Multipart uses the S3 multipart upload API. The upload is
+// aborted if any part fails so that no partial object is left behind.
+func (cmd *OutCommand) uploadS3Multipart(
+	ctx *concourse.CommandContext, client *http.Client,
+	file io.ReaderAt, size int64, partSize int64,
+) (string, error) {
+	initURL, err := withQuery(cmd.Source.URL, url.Values{"uploads": {""}})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := cmd.Source.newRequest("POST", initURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", cmd.contentType)
+
+	_, body, err := doMultipartRequest(client, req)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to initiate multipart upload")
+	}
+
+	var initiated s3InitiateResult
+	if err := xml.Unmarshal(body, &initiated); err != nil {
+		return "", errors.Wrap(err, "failed to decode multipart upload response")
+	}
+	if initiated.UploadID == "" {
+		return "", errors.New("no upload ID in multipart upload response")
+	}
+
+	uploadQuery := url.Values{"uploadId": {initiated.UploadID}}
+
+	etag, err := cmd.completeS3Multipart(
+		ctx, client, file, size, partSize, uploadQuery)
+	if err != nil {
+		abortURL, _ := withQuery(cmd.Source.URL, uploadQuery)
+		cmd.abortUpload(ctx, client, abortURL)
+		return "", err
+	}
+
+	return etag, nil
+}
+
+func (cmd *OutCommand) completeS3Multipart(
+	ctx *concourse.CommandContext, client *http.Client,
+	file io.ReaderAt, size int64, partSize int64, uploadQuery url.Values,
+) (string, error) {
+	var complete s3CompleteUpload
+
+	err := forEachPart(size, partSize, func(number int, start, end int64) error {
+		partURL, err := withQuery(cmd.Source.URL, url.Values{
+			"partNumber": {strconv.Itoa(number)},
+			"uploadId":   uploadQuery["uploadId"],
+		})
+		if err != nil {
+			return err
+		}
+
+		res, err := cmd.uploadPart(client, "PUT", partURL, file, start, end)
+		if err != nil {
+			return errors.Wrapf(err, "failed to upload part %d", number)
+		}
+
+		etag := res.Header.Get("ETag")
+		if etag == "" {
+			return errors.Errorf("no ETag returned for part %d", number)
+		}
+
+		complete.Parts = append(complete.Parts, s3Part{
+			PartNumber: number,
+			ETag:       etag,
+		})
+
+		fmt.Fprintf(ctx.Log, "uploaded part %d with ETag %s, %d of %d bytes\n",
+			number, etag, end, size)
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	data, err := xml.Marshal(complete)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode part list")
+	}
+
+	completeURL, err := withQuery(cmd.Source.URL, uploadQuery)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := cmd.Source.newRequest("POST", completeURL, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/xml")
+
+	_, body, err := doMultipartRequest(client, req)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to complete multipart upload")
+	}
+
+	var result s3CompleteResult
+	if err := xml.Unmarshal(body, &result); err != nil {
+		return "", errors.Wrap(err, "failed to decode complete upload response")
+	}
+	if result.XMLName.Local == "Error" {
+		return "", errors.Errorf(
+			"failed to complete multipart upload: %s: %s",
+			result.Code, result.Message)
+	}
+
+	return result.ETag, nil
+}
+
+// uploadAzureBlocks uploads the file as Azure blocks and commits them with
+// a block list. Uncommitted blocks are discarded by Azure, so nothing
+// has to be cleaned up on failure.
+func (cmd *OutCommand) uploadAzureBlocks(
+	ctx *concourse.CommandContext, client *http.Client,
+	file io.ReaderAt, size int64, partSize int64,
+) (string, error) {
+	var list azureBlockList
+
+	err := forEachPart(size, partSize, func(number int, start, end int64) error {
+		// Block IDs must have the same length within a blob.
+		blockID := base64.StdEncoding.EncodeToString(
+			[]byte(fmt.Sprintf("block-%08d", number)))
+
+		blockURL, err := withQuery(cmd.Source.URL, url.Values{
+			"comp":    {"block"},
+			"blockid": {blockID},
+		})
+		if err != nil {
+			return err
+		}
+
+		if _, err := cmd.uploadPart(client, "PUT", blockURL, file, start, end); err != nil {
+			return errors.Wrapf(err, "failed to upload block %d", number)
+		}
+
+		list.Latest = append(list.Latest, blockID)
+
+		fmt.Fprintf(ctx.Log, "uploaded block %d, %d of %d bytes\n",
+			number, end, size)
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	data, err := xml.Marshal(list)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode block list")
+	}
+
+	listURL, err := withQuery(cmd.Source.URL, url.Values{"comp": {"blocklist"}})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := cmd.Source.newRequest("PUT", listURL, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/xml")
+	req.Header.Set("x-ms-blob-content-type", cmd.contentType)
+
+	res, _, err := doMultipartRequest(client, req)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to commit block list")
+	}
+
+	return res.Header.Get("ETag"), nil
+}
+
+// uploadGCSResumable uses a GCS resumable upload session. The session is
+// cancelled if any part fails.
+func (cmd *OutCommand) uploadGCSResumable(
+	ctx *concourse.CommandContext, client *http.Client,
+	file io.ReaderAt, size int64, partSize int64,
+) (string, error) {
+	req, err := cmd.Source.newRequest("POST", cmd.Source.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("x-goog-resumable", "start")
+	req.Header.Set("Content-Type", cmd.contentType)
+
+	res, _, err := doMultipartRequest(client, req)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to start resumable upload")
+	}
+
+	session := res.Header.Get("Location")
+	if session == "" {
+		return "", errors.New("no session URL in resumable upload response")
+	}
+
+	var etag string
+	err = forEachPart(size, partSize, func(number int, start, end int64) error {
+		req, err := cmd.Source.newRequest("PUT", session,
+			io.NewSectionReader(file, start, end-start))
+		if err != nil {
+			return err
+		}
+		req.ContentLength = end - start
+		req.Header.Set("Content-Range",
+			fmt.Sprintf("bytes %d-%d/%d", start, end-1, size))
+
+		res, err := client.Do(req)
+		if err != nil {
+			return errors.Wrapf(err, "failed to upload part %d", number)
+		}
+		_, _ = io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+
+		// GCS responds with 308 until the last part has been uploaded.
+		switch {
+		case res.StatusCode == http.StatusPermanentRedirect && end < size:
+		case res.StatusCode >= 200 && res.StatusCode <= 299 && end == size:
+			etag = res.Header.Get("ETag")
+		default:
+			return errors.Errorf(
+				"unexpected response status %q for part %d", res.Status, number)
+		}
+
+		fmt.Fprintf(ctx.Log, "uploaded part %d, %d of %d bytes\n",
+			number, end, size)
+
+		return nil
+	})
+	if err != nil {
+		cmd.abortUpload(ctx, client, session)
+		return "", err
+	}
+
+	return etag, nil
+}
+
+// uploadPart uploads the bytes start to end of the file.
+func (cmd *OutCommand) uploadPart(
+	client *http.Client, method, partURL string,
+	file io.ReaderAt, start, end int64,
+) (*http.Response, error) {
+	req, err := cmd.Source.newRequest(method, partURL,
+		io.NewSectionReader(file, start, end-start))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = end - start
+
+	return doUploadRequest(client, req)
+}
+
+// abortUpload cancels a multipart upload with a DELETE request. Failures
+// are only logged as the upload already has failed.
+func (cmd *OutCommand) abortUpload(
+	ctx *concourse.CommandContext, client *http.Client, abortURL string,
+) {
+	req, err := cmd.Source.newRequest("DELETE", abortURL, nil)
+	if err != nil {
+		fmt.Fprintln(ctx.Log, "failed to abort upload:", err.Error())
+		return
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintln(ctx.Log, "failed to abort upload:", err.Error())
+		return
+	}
+	res.Body.Close()
+
+	fmt.Fprintln(ctx.Log, "aborted upload:", res.Status)
+}
+
+// forEachPart calls fn with the 1-based number and byte range of each
+// part of the file.
+func forEachPart(size, partSize int64, fn func(number int, start, end int64) error) error {
+	number := 1
+	for start := int64(0); start < size; start += partSize {
+		end := start + partSize
+		if end > size {
+			end = size
+		}
+
+		if err := fn(number, start, end); err != nil {
+			return err
+		}
+		number++
+	}
+
+	return nil
+}
+
+// withQuery adds the values to the query of the URL, keeping existing
+// parameters like f.ex. SAS tokens.
+func withQuery(rawURL string, values url.Values) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to parse URL")
+	}
+
+	query := u.Query()
+	for name := range values {
+		query[name] = values[name]
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// doMultipartRequest performs a request and fails on non-2xx responses.
+// The response body is returned.
+func doMultipartRequest(client *http.Client, req *http.Request) (
+	*http.Response, []byte, error,
+) {
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to perform request")
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read response")
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, nil, errors.Errorf("unexpected response status %q", res.Status)
+	}
+
+	return res, body, nil
+}
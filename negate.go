@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Sydsvenskan/concourse"
+	"github.com/pkg/errors"
+)
+
+// checkNegated inverts the change detection: the resource is "present"
+// while it responds with a 2xx status and a new "absent" version is
+// emitted when it stops doing so, with the status or the class of the
+// request error if it can't be reached. A "present" version is emitted
+// when the resource becomes available again so that the next loss is
+// detected.
+func (cmd *CheckCommand) checkNegated(client *http.Client) (
+	*concourse.CommandResponse, error,
+) {
+	var resp concourse.CommandResponse
+
+	if cmd.Version != nil {
+		resp.Versions = append(resp.Versions, cmd.Version)
+	}
+
+	req, err := cmd.Source.newRequest("GET", cmd.Source.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var version concourse.ResourceVersion
+
+	res, err := client.Do(req)
+	if err != nil {
+		// A resource that can't be connected to is as gone as one
+		// that responds with a error.
+		class, ok := classifyCheckError(err)
+		if !ok {
+			return nil, errors.Wrap(err, "failed to perform request")
+		}
+		if class == "" {
+			class = "request_error"
+		}
+
+		version = concourse.ResourceVersion{
+			"state": "absent",
+			"error": class,
+		}
+	} else {
+		res.Body.Close()
+
+		version = concourse.ResourceVersion{"state": "present"}
+		if res.StatusCode < 200 || res.StatusCode > 299 {
+			version = concourse.ResourceVersion{
+				"state":  "absent",
+				"status": strconv.Itoa(res.StatusCode),
+			}
+		}
+	}
+
+	previous := cmd.Version["state"]
+	if previous == "" {
+		previous = "present"
+	}
+
+	if version["state"] == previous && !cmd.alwaysEmit() {
+		return &resp, nil
+	}
+
+	resp.Versions = []concourse.ResourceVersion{
+		version,
+	}
+
+	return &resp, nil
+}
+
+// inNegated writes the state of a negated version, the resource contents
+// aren't downloaded as they're typically missing.
+func (cmd *InCommand) inNegated(ctx *concourse.CommandContext) (
+	*concourse.CommandResponse, error,
+) {
+	var resp concourse.CommandResponse
+
+	state := cmd.Version["state"]
+	if state == "" {
+		state = "present"
+	}
+
+	if err := ctx.File("state", []byte(state)); err != nil {
+		return nil, err
+	}
+
+	resp.Version = cmd.Version
+	resp.AddMeta("state", state)
+	if cmd.Version["status"] != "" {
+		resp.AddMeta("status", cmd.Version["status"])
+	}
+	if cmd.Version["error"] != "" {
+		resp.AddMeta("error", cmd.Version["error"])
+	}
+
+	return &resp, nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Sydsvenskan/concourse"
+	"github.com/pkg/errors"
+)
+
+const defaultNuGetSearchURL = "https://api.nuget.org/v3/query"
+
+type nuGetSearchResult struct {
+	Data []struct {
+		ID       string `json:"id"`
+		Versions []struct {
+			Version string `json:"version"`
+			// ID is the URL of the registration leaf of the version.
+			ID string `json:"@id"`
+		} `json:"versions"`
+	} `json:"data"`
+}
+
+type nuGetRegistrationLeaf struct {
+	CatalogEntry   string `json:"catalogEntry"`
+	PackageContent string `json:"packageContent"`
+}
+
+type nuGetCatalogEntry struct {
+	PackageHash          string `json:"packageHash"`
+	PackageHashAlgorithm string `json:"packageHashAlgorithm"`
+}
+
+// nuGetVersion is a package version and the URL of its registration leaf.
+type nuGetVersion struct {
+	Version         string
+	RegistrationURL string
+}
+
+// fetchNuGetJSON decodes the JSON response for a NuGet API URL into v.
+func (s Source) fetchNuGetJSON(client *http.Client, apiURL string, v interface{}) error {
+	req, err := s.newRequest("GET", apiURL, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to perform request")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return statusError(res,
+			"unexpected response status %q for %s", res.Status, apiURL)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return errors.Wrapf(err, "failed to decode response for %s", apiURL)
+	}
+
+	return nil
+}
+
+// fetchNuGetVersions lists the versions of the package with the NuGet V3
+// search API at URL, which defaults to nuget.org.
+func (s Source) fetchNuGetVersions(client *http.Client) ([]nuGetVersion, error) {
+	if s.PackageID == "" {
+		return nil, errors.New("package_id is required in nuget mode")
+	}
+
+	searchURL := s.URL
+	if searchURL == "" {
+		searchURL = defaultNuGetSearchURL
+	}
+
+	searchURL, err := withQuery(searchURL, url.Values{
+		"q":           {"PackageId:" + s.PackageID},
+		"prerelease":  {strconv.FormatBool(s.Prerelease)},
+		"semVerLevel": {"2.0.0"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result nuGetSearchResult
+	if err := s.fetchNuGetJSON(client, searchURL, &result); err != nil {
+		return nil, errors.Wrap(err, "failed to search for NuGet package")
+	}
+
+	for _, pkg := range result.Data {
+		if !strings.EqualFold(pkg.ID, s.PackageID) {
+			continue
+		}
+
+		versions := make([]nuGetVersion, len(pkg.Versions))
+		for i, v := range pkg.Versions {
+			versions[i] = nuGetVersion{
+				Version:         v.Version,
+				RegistrationURL: v.ID,
+			}
+		}
+
+		return versions, nil
+	}
+
+	return nil, errors.Errorf("the NuGet package %q wasn't found", s.PackageID)
+}
+
+// checkNuGet returns the package versions that are newer than the current
+// version, or all of them if there is no current version.
+func (cmd *CheckCommand) checkNuGet(client *http.Client) (
+	*concourse.CommandResponse, error,
+) {
+	var resp concourse.CommandResponse
+
+	if cmd.Version != nil {
+		resp.Versions = append(resp.Versions, cmd.Version)
+	}
+
+	versions, err := cmd.Source.fetchNuGetVersions(client)
+	if err != nil {
+		return nil, err
+	}
+
+	// The search API lists versions in ascending order, the comparison
+	// is only needed if the current version has been unlisted.
+	var newer []nuGetVersion
+	if current := cmd.Version["version"]; current != "" {
+		found := false
+		for i, v := range versions {
+			if v.Version == current {
+				newer = versions[i+1:]
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			values := []string{current}
+			for _, v := range versions {
+				values = append(values, v.Version)
+			}
+			typ := inferSortType(values)
+
+			for _, v := range versions {
+				if typ.compare(v.Version, current) > 0 {
+					newer = append(newer, v)
+				}
+			}
+		}
+	} else {
+		newer = versions
+	}
+
+	if len(newer) == 0 {
+		return &resp, nil
+	}
+
+	resp.Versions = nil
+	for _, v := range newer {
+		resp.Versions = append(resp.Versions, concourse.ResourceVersion{
+			"version": v.Version,
+		})
+	}
+
+	return &resp, nil
+}
+
+// inNuGet downloads the .nupkg of the version and verifies it against the
+// SHA512 hash in its catalog entry.
+func (cmd *InCommand) inNuGet(
+	ctx *concourse.CommandContext, client *http.Client,
+) (*concourse.CommandResponse, error) {
+	var resp concourse.CommandResponse
+
+	version := cmd.Version["version"]
+	if version == "" {
+		return nil, errors.New("no version to fetch")
+	}
+
+	versions, err := cmd.Source.fetchNuGetVersions(client)
+	if err != nil {
+		return nil, err
+	}
+
+	var registrationURL string
+	for _, v := range versions {
+		if v.Version == version {
+			registrationURL = v.RegistrationURL
+			break
+		}
+	}
+	if registrationURL == "" {
+		return nil, errors.Errorf(
+			"version %q of the NuGet package wasn't found", version)
+	}
+
+	var leaf nuGetRegistrationLeaf
+	if err := cmd.Source.fetchNuGetJSON(client, registrationURL, &leaf); err != nil {
+		return nil, errors.Wrap(err, "failed to fetch registration")
+	}
+
+	var entry nuGetCatalogEntry
+	if err := cmd.Source.fetchNuGetJSON(client, leaf.CatalogEntry, &entry); err != nil {
+		return nil, errors.Wrap(err, "failed to fetch catalog entry")
+	}
+
+	if !strings.EqualFold(entry.PackageHashAlgorithm, "SHA512") {
+		return nil, errors.Errorf(
+			"unsupported package hash algorithm %q", entry.PackageHashAlgorithm)
+	}
+
+	filename := filepath.Join(ctx.Directory(), "downloaded")
+
+	_, sum, err := cmd.Source.download(client, leaf.PackageContent, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	packageHash, err := sha512File(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if packageHash != entry.PackageHash {
+		return nil, errors.Errorf(
+			"unexpected SHA512 package hash %q, expected %q",
+			packageHash, entry.PackageHash)
+	}
+
+	resp.Version = concourse.ResourceVersion{"version": version}
+	resp.AddMeta("package-id", cmd.Source.PackageID)
+	resp.AddMeta("url", leaf.PackageContent)
+	resp.AddMeta("sha1", sum)
+	resp.AddMeta("sha512", packageHash)
+
+	return &resp, nil
+}
+
+// sha512File returns the base64 encoded SHA512 hash of the file, the
+// encoding used by NuGet.
+func sha512File(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to open download")
+	}
+	defer f.Close()
+
+	h := sha512.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", errors.Wrap(err, "failed to hash download")
+	}
+
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
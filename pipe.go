@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/Sydsvenskan/concourse"
+	"github.com/pkg/errors"
+)
+
+// responseWriter is the writer that the command response is written to. It
+// can be redirected so that stdout can be used for other output.
+type responseWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	return rw.w.Write(p)
+}
+
+// Redirect sends the command response to w instead.
+func (rw *responseWriter) Redirect(w io.Writer) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	rw.w = w
+}
+
+// versionFD is the file descriptor the command response is written to
+// when the download is piped to stdout.
+const versionFD = 3
+
+// openVersionFD claims file descriptor 3 if it has been passed to the
+// process, nil is returned otherwise. The descriptor is only wrapped in a
+// file once it's known to be inherited, as the file closes it when
+// finalized and it could otherwise belong to the Go runtime.
+func openVersionFD() *os.File {
+	if !inheritedFD(versionFD) {
+		return nil
+	}
+	return os.NewFile(versionFD, "version")
+}
+
+// inheritedFD tells if fd is open and was passed to the process. The
+// descriptors of the process itself, like the epoll descriptor of the Go
+// runtime, are opened close-on-exec, which a inherited one can't be.
+func inheritedFD(fd uintptr) bool {
+	flags, _, errno := syscall.Syscall(
+		syscall.SYS_FCNTL, fd, syscall.F_GETFD, 0)
+	return errno == 0 && flags&syscall.FD_CLOEXEC == 0
+}
+
+// validatePipeOutput rejects the params and source options that need the
+// downloaded file, as there is none when the output is piped.
+func (cmd *InCommand) validatePipeOutput() error {
+	if cmd.versionOut == nil {
+		return errors.Errorf(
+			"file descriptor %d must be open to pipe the output", versionFD)
+	}
+
+	conflicts := []struct {
+		name string
+		set  bool
+	}{
+		{"parallel_hashes", len(cmd.Source.ParallelHashes) > 0},
+		{"compute_all_hashes", cmd.Params.ComputeAllHashes},
+		{"checksum_url", cmd.Source.ChecksumURL != ""},
+		{"verify_zip", cmd.Params.VerifyZip},
+		{"version extraction", cmd.Source.extractsVersion()},
+		{"version_fields", len(cmd.Source.VersionFields) > 0},
+		{"redact_json_fields", len(cmd.Params.RedactJSONFields) > 0},
+		{"composite_version_headers", len(cmd.Source.CompositeVersionHeaders) > 0},
+		{"size_precheck", cmd.Source.SizePrecheck},
+		{"base64_encode_binary", cmd.Params.Base64EncodeBinary},
+		{"preserve_timestamps", cmd.Params.PreserveTimestamps},
+		{"mark_executable", cmd.Params.MarkExecutable},
+	}
+	for _, conflict := range conflicts {
+		if conflict.set {
+			return errors.Errorf(
+				"pipe_output can't be combined with %s", conflict.name)
+		}
+	}
+
+	return nil
+}
+
+// pipeOutput streams the response body to stdout and redirects the command
+// response to file descriptor 3.
+func (cmd *InCommand) pipeOutput(
+	res *http.Response, version concourse.ResourceVersion,
+) (*concourse.CommandResponse, error) {
+	var resp concourse.CommandResponse
+
+	if cmd.response == nil || cmd.versionOut == nil {
+		return nil, errors.New("the command response can't be redirected")
+	}
+	cmd.response.Redirect(cmd.versionOut)
+
+	h := sha1.New()
+	_, err := io.Copy(io.MultiWriter(os.Stdout, h), res.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to pipe download to stdout")
+	}
+	version["sha1"] = fmt.Sprintf("%x", h.Sum(nil))
+
+	hash := cmd.Version["sha1"]
+	if hash != "" && version["sha1"] != hash {
+		return nil, errors.Errorf("unexpected SHA1 content hash %q, expected %q",
+			version["sha1"], hash,
+		)
+	}
+
+	resp.Version = version
+	if cmd.Source.CaptureS3VersionID {
+		setS3Version(&resp, version)
+	}
+	resp.AddMeta("content-type", res.Header.Get("Content-type"))
+
+	return &resp, nil
+}
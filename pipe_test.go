@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestInheritedFD(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %v", err)
+	}
+	defer r.Close()
+
+	// Descriptors opened by the process are close-on-exec
+	if inheritedFD(r.Fd()) {
+		t.Error("expected a descriptor of our own not to be inherited")
+	}
+
+	// As if it had been passed to the process
+	_, _, errno := syscall.Syscall(syscall.SYS_FCNTL, w.Fd(), syscall.F_SETFD, 0)
+	if errno != 0 {
+		t.Fatalf("failed to clear close-on-exec: %v", errno)
+	}
+	if !inheritedFD(w.Fd()) {
+		t.Error("expected a descriptor without close-on-exec to be inherited")
+	}
+
+	fd := w.Fd()
+	w.Close()
+	if inheritedFD(fd) {
+		t.Error("expected a closed descriptor not to be inherited")
+	}
+}
+
+func TestPipeOutputWithoutVersionFD(t *testing.T) {
+	// go test doesn't pass any descriptors beyond stderr to the test
+	versionOut := openVersionFD()
+	if versionOut != nil {
+		t.Fatalf("expected file descriptor %d not to be claimed", versionFD)
+	}
+
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	transport := &countingTransport{}
+	cmd := InCommand{
+		Source: Source{
+			URL:    srv.URL,
+			Client: &http.Client{Transport: transport},
+		},
+		Params:     InParams{PipeOutput: true},
+		response:   &responseWriter{w: os.Stdout},
+		versionOut: versionOut,
+	}
+
+	_, err := cmd.HandleCommand(newTestContext(t, "in", t.TempDir()))
+	if err == nil || !strings.Contains(err.Error(), "must be open") {
+		t.Fatalf("expected pipe_output to be rejected, got %v", err)
+	}
+
+	if transport.Requests != 0 {
+		t.Errorf("expected no requests, got %d", transport.Requests)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// redactedHeaders are headers whose values never are logged.
+var redactedHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"WWW-Authenticate",
+	"Proxy-Authenticate",
+}
+
+// requestLogEntry is a line in the request log.
+type requestLogEntry struct {
+	Time            time.Time   `json:"time"`
+	Method          string      `json:"method"`
+	URL             string      `json:"url"`
+	RequestHeaders  http.Header `json:"request_headers"`
+	Status          int         `json:"status,omitempty"`
+	ResponseHeaders http.Header `json:"response_headers,omitempty"`
+	Bytes           int64       `json:"bytes"`
+	DurationMS      int64       `json:"duration_ms"`
+	Error           string      `json:"error,omitempty"`
+}
+
+// requestLogTransport writes a JSON line for every request to a file once
+// the response body has been closed. The values of the headers in
+// redactedHeaders and Redact are replaced.
+type requestLogTransport struct {
+	Next   http.RoundTripper
+	Redact []string
+
+	mu  sync.Mutex
+	out *os.File
+}
+
+func newRequestLogTransport(
+	filename string, redact []string, next http.RoundTripper,
+) (*requestLogTransport, error) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open request log")
+	}
+
+	return &requestLogTransport{Next: next, Redact: redact, out: f}, nil
+}
+
+// Close syncs and closes the request log, requests that finish later
+// aren't logged.
+func (t *requestLogTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.out == nil {
+		return nil
+	}
+
+	syncErr := t.out.Sync()
+	err := t.out.Close()
+	t.out = nil
+
+	if syncErr != nil {
+		return errors.Wrap(syncErr, "failed to sync request log")
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to close request log")
+	}
+
+	return nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *requestLogTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	entry := &requestLogEntry{
+		Time:           time.Now().UTC(),
+		Method:         req.Method,
+		URL:            redactURL(req.URL),
+		RequestHeaders: redactHeader(req.Header, t.Redact),
+	}
+
+	res, err := t.Next.RoundTrip(req)
+	if err != nil {
+		entry.Error = err.Error()
+		t.write(entry)
+		return nil, err
+	}
+
+	entry.Status = res.StatusCode
+	entry.ResponseHeaders = redactHeader(res.Header, t.Redact)
+	res.Body = &loggedBody{ReadCloser: res.Body, entry: entry, log: t}
+
+	return res, nil
+}
+
+func (t *requestLogTransport) write(entry *requestLogEntry) {
+	entry.DurationMS = int64(time.Since(entry.Time) / time.Millisecond)
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.out == nil {
+		return
+	}
+	_, _ = t.out.Write(append(data, '\n'))
+}
+
+// loggedBody counts the bytes read and logs the request when closed.
+type loggedBody struct {
+	io.ReadCloser
+	entry *requestLogEntry
+	log   *requestLogTransport
+	once  sync.Once
+}
+
+func (b *loggedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.entry.Bytes += int64(n)
+	return n, err
+}
+
+func (b *loggedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.log.write(b.entry) })
+	return err
+}
+
+// redactURL replaces credentials and query parameter values in the URL.
+func redactURL(u *url.URL) string {
+	redacted := *u
+	if redacted.User != nil {
+		redacted.User = url.User("REDACTED")
+	}
+
+	query := redacted.Query()
+	for name := range query {
+		query[name] = []string{"REDACTED"}
+	}
+	redacted.RawQuery = query.Encode()
+
+	return redacted.String()
+}
+
+// redactHeader copies the header with the values of authentication
+// headers and the extra headers replaced.
+func redactHeader(header http.Header, extra []string) http.Header {
+	redacted := header.Clone()
+	for _, names := range [][]string{redactedHeaders, extra} {
+		for _, name := range names {
+			if len(redacted.Values(name)) > 0 {
+				redacted.Set(name, "REDACTED")
+			}
+		}
+	}
+	return redacted
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"crypto/md5"
 	"crypto/sha1"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Sydsvenskan/concourse"
@@ -14,7 +18,13 @@ import (
 )
 
 func main() {
-	context, err := concourse.NewContext(os.Args, os.Stdin, os.Stdout, os.Stderr)
+	// Claimed first so that it can't be confused with a descriptor of
+	// our own.
+	versionOut := openVersionFD()
+
+	response := &responseWriter{w: os.Stdout}
+
+	context, err := concourse.NewContext(os.Args, os.Stdin, response, os.Stderr)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "failed to create command context:", err.Error())
 		os.Exit(1)
@@ -23,7 +33,7 @@ func main() {
 
 	context.Handle(&concourse.Resource{
 		Check: &CheckCommand{},
-		In:    &InCommand{},
+		In:    &InCommand{response: response, versionOut: versionOut},
 		Out:   &OutCommand{},
 	})
 }
@@ -40,7 +50,75 @@ type CheckCommand struct {
 func (cmd *CheckCommand) HandleCommand(ctx *concourse.CommandContext) (
 	*concourse.CommandResponse, error,
 ) {
-	var err error
+	if cmd.Source.CheckJitterMax != "" {
+		if err := cmd.jitter(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	// Responses from the error policies aren't debounced
+	var checkFailed bool
+
+	resp, err := cmd.check(ctx)
+	if err != nil {
+		checkFailed = true
+
+		resp, err = cmd.handleCheckError(ctx, err)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cmd.Source.CheckDebounceCount > 1 && !checkFailed && !cmd.alwaysEmit() {
+		resp, err = cmd.debounce(ctx, resp)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cmd.Source.VersionFilterRegexp != "" {
+		filtered, err := filterVersions(
+			resp.Versions, cmd.Source.VersionFilterRegexp,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(filtered) == 0 && len(resp.Versions) > 0 && cmd.alwaysEmit() {
+			filtered = resp.Versions[len(resp.Versions)-1:]
+		}
+
+		resp.Versions = filtered
+	}
+
+	// Versions are returned in chronological order, so the last one is
+	// the latest.
+	if cmd.Source.CheckOnlyLatest && len(resp.Versions) > 1 {
+		resp.Versions = resp.Versions[len(resp.Versions)-1:]
+	}
+
+	err = sortVersions(resp.Versions,
+		cmd.Source.VersionSortBy, cmd.Source.VersionSortType,
+		cmd.Source.VersionSortOrder,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to sort versions")
+	}
+
+	if cmd.Source.VersionIncludesSourceHash {
+		sourceHash, err := cmd.Source.hash()
+		if err != nil {
+			return nil, err
+		}
+		resp.Versions = withSourceHash(resp.Versions, sourceHash)
+	}
+
+	return resp, nil
+}
+
+func (cmd *CheckCommand) check(ctx *concourse.CommandContext) (
+	*concourse.CommandResponse, error,
+) {
 	var resp concourse.CommandResponse
 
 	if cmd.Version != nil {
@@ -48,39 +126,84 @@ func (cmd *CheckCommand) HandleCommand(ctx *concourse.CommandContext) (
 	}
 
 	etag := cmd.Version["etag"]
+	lastModified := cmd.Version["last-modified"]
 	hash := cmd.Version["sha1"]
 
-	timeout := 5 * time.Minute
-	if cmd.Source.Timeout != "" {
-		timeout, err = time.ParseDuration(cmd.Source.Timeout)
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to parse timeout")
-		}
+	if err := cmd.Source.validate(); err != nil {
+		return nil, err
 	}
 
-	client := http.Client{
-		Timeout: timeout,
+	client, closeClient, err := cmd.Source.httpClient(ctx.Log)
+	if err != nil {
+		return nil, err
 	}
+	defer closeClient()
 
-	req, err := http.NewRequest("GET", cmd.Source.URL, nil)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create request")
+	if cmd.Source.DockerRegistry != nil {
+		return cmd.checkDockerRegistry(client)
+	}
+
+	if cmd.Source.VersionNegate {
+		return cmd.checkNegated(client)
+	}
+
+	if cmd.Source.VersionStrategy == "response_time" {
+		return cmd.checkResponseTime(client)
+	}
+
+	if cmd.Source.VersionStrategy == "rss_pubdate" {
+		return cmd.checkRSSPubDate(client)
+	}
+
+	if cmd.Source.Mode == "maven-metadata" {
+		return cmd.checkMavenMetadata(client)
+	}
+
+	if cmd.Source.Mode == "git-refs" {
+		return cmd.checkGitRefs(client)
+	}
+
+	if cmd.Source.Mode == "nuget" {
+		return cmd.checkNuGet(client)
+	}
+
+	if cmd.Source.Mode == "github-packages" {
+		return cmd.checkGitHubPackages(client)
 	}
 
-	// Add source headers
-	for name, values := range cmd.Source.Headers {
-		req.Header[name] = append(req.Header[name], values...)
+	if cmd.Source.CaptureS3VersionID {
+		return cmd.checkS3VersionID(client)
+	}
+
+	// The first check is always made so that there is something to make
+	// the following ones conditional on.
+	if cmd.Source.SkipUnconditionalCheck && len(cmd.Version) > 0 &&
+		etag == "" && lastModified == "" {
+		fmt.Fprintln(ctx.Log, "skipping check, no conditional request can be made")
+		return &resp, nil
+	}
+
+	if cmd.Source.SizePrecheck && cmd.Version["size"] != "" {
+		unchanged, err := cmd.sizeUnchanged(ctx, client)
+		if err != nil {
+			return nil, err
+		}
+		if unchanged {
+			return &resp, nil
+		}
+	}
+
+	req, err := cmd.Source.newRequest("GET", cmd.Source.URL, nil)
+	if err != nil {
+		return nil, err
 	}
 
 	if etag != "" {
 		req.Header.Add("If-None-Match", etag)
 	}
 
-	if cmd.Source.BasicAuth != nil {
-		req.SetBasicAuth(
-			cmd.Source.BasicAuth.User,
-			cmd.Source.BasicAuth.Password,
-		)
+	if lastModified != "" {
+		req.Header.Add("If-Modified-Since", lastModified)
 	}
 
 	res, err := client.Do(req)
@@ -93,9 +216,65 @@ func (cmd *CheckCommand) HandleCommand(ctx *concourse.CommandContext) (
 		return &resp, nil
 	}
 
+	if (res.StatusCode < 200 || res.StatusCode > 299) &&
+		!cmd.Source.versionsErrorStatus(res.StatusCode) {
+		return nil, statusError(res, "unexpected response status %q", res.Status)
+	}
+
+	if cmd.Source.ErrorOnEmptyBody {
+		if err := ensureNonEmptyBody(res); err != nil {
+			return nil, err
+		}
+	}
+
 	version := concourse.ResourceVersion{}
 	responseETag := res.Header.Get("ETag")
-	if responseETag != "" {
+	if len(cmd.Source.VersionPrecedence) > 0 {
+		name, value, err := cmd.Source.precedenceVersion(res)
+		if err != nil {
+			return nil, err
+		}
+
+		if value == cmd.Version[name] {
+			return &resp, nil
+		}
+
+		version[name] = value
+	} else if len(cmd.Source.CompositeVersionHeaders) > 0 {
+		version["header_composite"] = cmd.Source.headerComposite(res.Header)
+
+		if version["header_composite"] == cmd.Version["header_composite"] {
+			return &resp, nil
+		}
+	} else if len(cmd.Source.VersionFields) > 0 {
+		data, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read response contents")
+		}
+
+		version, err = cmd.Source.fieldsVersion(res.Header, data)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to extract version fields")
+		}
+
+		if sameFields(version, cmd.Version) {
+			return &resp, nil
+		}
+	} else if cmd.Source.extractsVersion() {
+		data, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read response contents")
+		}
+
+		version["version"], err = cmd.Source.extractVersion(data)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to extract version")
+		}
+
+		if version["version"] == cmd.Version["version"] {
+			return &resp, nil
+		}
+	} else if responseETag != "" {
 		// Catch cases where an identical Etag is returned but the
 		// server responded with a 200 OK anyway.
 		if responseETag == etag {
@@ -117,6 +296,16 @@ func (cmd *CheckCommand) HandleCommand(ctx *concourse.CommandContext) (
 		}
 	}
 
+	if cmd.Source.SkipUnconditionalCheck {
+		if responseLastModified := res.Header.Get("Last-Modified"); responseLastModified != "" {
+			version["last-modified"] = responseLastModified
+		}
+	}
+
+	if cmd.Source.SizePrecheck && res.ContentLength >= 0 {
+		version["size"] = strconv.FormatInt(res.ContentLength, 10)
+	}
+
 	// Prepend the new version to the versions array
 	resp.Versions = []concourse.ResourceVersion{
 		version,
@@ -125,11 +314,342 @@ func (cmd *CheckCommand) HandleCommand(ctx *concourse.CommandContext) (
 	return &resp, nil
 }
 
+// sizeUnchanged compares the Content-Length reported for a HEAD request
+// with the size of the current version.
+func (cmd *CheckCommand) sizeUnchanged(
+	ctx *concourse.CommandContext, client *http.Client,
+) (bool, error) {
+	req, err := cmd.Source.newRequest("HEAD", cmd.Source.URL, nil)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to perform HEAD request")
+	}
+	res.Body.Close()
+
+	if res.ContentLength < 0 {
+		fmt.Fprintln(ctx.Log, "size precheck: no Content-Length in response")
+		return false, nil
+	}
+
+	size := strconv.FormatInt(res.ContentLength, 10)
+	if size != cmd.Version["size"] {
+		fmt.Fprintf(ctx.Log, "size precheck: size changed from %s to %s bytes\n",
+			cmd.Version["size"], size)
+		return false, nil
+	}
+
+	fmt.Fprintf(ctx.Log, "size precheck: size unchanged at %s bytes, skipping check\n", size)
+	return true, nil
+}
+
 type Source struct {
 	URL       string      `json:"url"`
 	Timeout   string      `json:"timeout"`
 	Headers   http.Header `json:"headers,omitempty"`
 	BasicAuth *BasicAuth  `json:"basic_auth,omitempty"`
+
+	// AllowHTTPRedirects can be set to false to refuse redirects from
+	// https:// to http:// URLs.
+	AllowHTTPRedirects *bool `json:"allow_http_redirects,omitempty"`
+
+	// VersionSortBy is the name of the version field that versions
+	// returned by check are sorted by.
+	VersionSortBy string `json:"version_sort_by,omitempty"`
+	// VersionSortType is how the values are compared: "time" (RFC3339),
+	// "semver" or "string". It's inferred from the values if unset, and
+	// values that aren't of the configured type are an error.
+	VersionSortType string `json:"version_sort_type,omitempty"`
+	// VersionSortOrder is either "asc" (default) or "desc".
+	VersionSortOrder string `json:"version_sort_order,omitempty"`
+	// CheckOnlyLatest makes check return only the latest version instead
+	// of every version since the current one.
+	CheckOnlyLatest bool `json:"check_only_latest,omitempty"`
+	// VersionFilterRegexp drops versions from check that don't match. The
+	// value of single field versions is matched, otherwise the sorted
+	// "name=value" pairs separated by spaces.
+	VersionFilterRegexp string `json:"version_filter_regexp,omitempty"`
+	// CheckDebounceCount is the number of consecutive checks, spaced by
+	// CheckDebounceInterval (default 10s), that must see the same new
+	// versions before they're returned.
+	CheckDebounceCount    int    `json:"check_debounce_count,omitempty"`
+	CheckDebounceInterval string `json:"check_debounce_interval,omitempty"`
+	// CheckJitterMax is the longest duration check sleeps before making
+	// any requests. The jitter is seeded by $HOSTNAME so that it differs
+	// between workers but is consistent for each worker.
+	CheckJitterMax string `json:"check_jitter_max,omitempty"`
+
+	// VersionJSONPath extracts the version from the response JSON.
+	VersionJSONPath string `json:"version_jsonpath,omitempty"`
+	// VersionJSONPathFallback is used when VersionJSONPath doesn't match
+	// anything. It's evaluated as a JSON path if it starts with "$",
+	// otherwise it's used as a literal version.
+	VersionJSONPathFallback string `json:"version_jsonpath_fallback,omitempty"`
+	// JSONPathArrayStrategy decides how a array matched by the version JSON
+	// path is turned into a version: "first" (default), "last", "join"
+	// (comma separated), "count" or "hash".
+	JSONPathArrayStrategy string `json:"jsonpath_array_strategy,omitempty"`
+
+	// HTMLMetaName and HTMLMetaProperty extract the version from the
+	// content of a HTML <meta> tag with a matching name or property.
+	HTMLMetaName     string `json:"html_meta_name,omitempty"`
+	HTMLMetaProperty string `json:"html_meta_property,omitempty"`
+
+	// CoerceSemver normalises extracted versions like "v2.3", "r1234"
+	// or "4.2.1.Final" to semantic versions.
+	CoerceSemver bool `json:"coerce_semver,omitempty"`
+
+	// VersionTrimPrefixes is a ordered list of prefixes, the first one
+	// that matches is stripped from extracted versions.
+	VersionTrimPrefixes []string `json:"version_trim_prefixes,omitempty"`
+
+	// VersionMaxLength is the max length of a extracted version, defaults
+	// to 256.
+	VersionMaxLength int `json:"version_max_length,omitempty"`
+	// VersionTooLongStrategy decides what happens to versions that are
+	// too long: "truncate" (default) uses a sha256 hash of the version
+	// instead, "error" fails, and "use_hash" always uses the hash.
+	VersionTooLongStrategy string `json:"version_too_long_strategy,omitempty"`
+
+	// VersionFields extracts several independent fields into a composite
+	// version, a new version is emitted when any of them change.
+	VersionFields []VersionField `json:"version_fields,omitempty"`
+
+	// CompositeVersionHeaders versions the URL by a hash of the values of
+	// the listed response headers.
+	CompositeVersionHeaders []string `json:"composite_version_headers,omitempty"`
+
+	// DockerRegistry tracks the config digest of a image manifest in the
+	// registry at URL instead of the URL contents.
+	DockerRegistry *DockerRegistry `json:"docker_registry,omitempty"`
+
+	// Mode selects a alternative kind of source, "maven-metadata" tracks
+	// the versions listed in the maven-metadata.xml file at URL,
+	// "git-refs" the refs in a Git info/refs or packed-refs listing,
+	// "nuget" the versions of a NuGet package and "github-packages" the
+	// versions of a package in GitHub Packages.
+	Mode string `json:"mode,omitempty"`
+	// MavenPackaging is the file extension of Maven artifacts, defaults
+	// to "jar".
+	MavenPackaging string `json:"maven_packaging,omitempty"`
+	// RefRegexp selects the refs that are tracked in git-refs mode. Refs
+	// have no chronology, so they're emitted in natural order by name.
+	RefRegexp string `json:"ref_regexp,omitempty"`
+	// DownloadURLTemplate is a Go template for the URL that get
+	// downloads in git-refs mode, with {{.Ref}} and {{.SHA}} available.
+	DownloadURLTemplate string `json:"download_url_template,omitempty"`
+	// PackageID is the package tracked in nuget mode, using the NuGet V3
+	// search API at URL, which defaults to nuget.org. Prerelease versions
+	// are included if Prerelease is set.
+	PackageID  string `json:"package_id,omitempty"`
+	Prerelease bool   `json:"prerelease,omitempty"`
+	// Owner, PackageName and PackageType (f.ex. "container", "npm" or
+	// "maven") identify the package tracked in github-packages mode. The
+	// API is authenticated with Token.
+	Owner       string `json:"owner,omitempty"`
+	PackageName string `json:"package_name,omitempty"`
+	PackageType string `json:"package_type,omitempty"`
+	Token       string `json:"token,omitempty"`
+
+	// SNIBypass skips certificate verification for connections to
+	// SNIHostname, typically a host that's exempt from TLS inspection.
+	SNIBypass   bool   `json:"sni_bypass,omitempty"`
+	SNIHostname string `json:"sni_hostname,omitempty"`
+
+	// TLSMinVersion and TLSMaxVersion limit the TLS versions that are
+	// used: "1.0", "1.1", "1.2" or "1.3".
+	TLSMinVersion string `json:"tls_min_version,omitempty"`
+	TLSMaxVersion string `json:"tls_max_version,omitempty"`
+
+	// TLSClientCert and TLSClientKey are PEM files with a client
+	// certificate for mutual TLS.
+	TLSClientCert string `json:"tls_client_cert,omitempty"`
+	TLSClientKey  string `json:"tls_client_key,omitempty"`
+	// TLSClientCertReload re-reads the client certificate for every
+	// request instead of once at startup.
+	TLSClientCertReload bool `json:"tls_client_cert_reload,omitempty"`
+
+	// DialProxyURL is a HTTP CONNECT (http://) or SOCKS5 (socks5:// or
+	// socks5h://) proxy that all TCP connections are established through.
+	// Hostnames are resolved locally for socks5 and by the proxy for
+	// socks5h.
+	// Credentials are taken from the URL or DialProxyAuth.
+	DialProxyURL  string     `json:"dial_proxy_url,omitempty"`
+	DialProxyAuth *BasicAuth `json:"dial_proxy_auth,omitempty"`
+
+	// RequestLog is a file that a JSON line is appended to for every
+	// request, with query parameters and credentials redacted.
+	RequestLog string `json:"request_log,omitempty"`
+
+	// VersionNegate inverts change detection so that new versions are
+	// emitted when the URL stops responding with a 2xx status.
+	VersionNegate bool `json:"version_negate,omitempty"`
+
+	// SizePrecheck records the Content-Length in the version and makes
+	// check compare it using a HEAD request before fetching the contents.
+	SizePrecheck bool `json:"size_precheck,omitempty"`
+
+	// MaxResponseHeaderBytes limits the size of the response headers,
+	// requests fail if the server sends more than this. Defaults to 1MB
+	// as a hardening against memory exhaustion from huge headers.
+	MaxResponseHeaderBytes int64 `json:"max_response_header_bytes,omitempty"`
+
+	// ErrorOnEmptyBody fails check and get when the response body is
+	// empty.
+	ErrorOnEmptyBody bool `json:"error_on_empty_body,omitempty"`
+
+	// SkipUnconditionalCheck skips the check request when the current
+	// version has neither an etag nor a last-modified to make the request
+	// conditional on. The Last-Modified response header is added to the
+	// version as last-modified, and the first check is always made.
+	SkipUnconditionalCheck bool `json:"skip_unconditional_check,omitempty"`
+
+	// CheckAlwaysEmit makes check emit the current version when there is
+	// no stored version, even if skip_unconditional_check, version_negate,
+	// debouncing or filtering would have kept it from doing so.
+	CheckAlwaysEmit bool `json:"check_always_emit,omitempty"`
+
+	// OnCheckError decides what happens when a check request fails or
+	// gets a non-2xx response, in all modes: "version" (default), "fail"
+	// or "return_previous" to return the current version. "version"
+	// versions the contents of non-2xx responses of the URL itself like
+	// any other response and fails on other errors. Note that
+	// "return_previous" can mask real errors, like a moved or removed
+	// URL, so only use it for optional dependencies.
+	OnCheckError string `json:"on_check_error,omitempty"`
+	// On5xx, OnTimeout, OnDNSFailure and OnCertError override
+	// OnCheckError for specific kinds of check errors: "fail",
+	// "warn_and_skip" to log the error and return no versions, or
+	// "return_current_version". Unset, OnCheckError applies. Setting On5xx
+	// makes 5xx responses of the URL itself check errors.
+	On5xx        string `json:"on_5xx,omitempty"`
+	OnTimeout    string `json:"on_timeout,omitempty"`
+	OnDNSFailure string `json:"on_dns_failure,omitempty"`
+	OnCertError  string `json:"on_cert_error,omitempty"`
+
+	// CaptureS3VersionID versions the URL by the x-amz-version-id of the
+	// S3 object, check uses a HEAD request to get it. Get requests the
+	// version by its ID, except for presigned URLs where the latest
+	// version has to match. The SHA1 and ETag are added as metadata.
+	CaptureS3VersionID bool `json:"capture_s3_version_id,omitempty"`
+
+	// ParallelHashes lists hash algorithms (md5, sha1, sha256, sha512)
+	// that are computed in the same pass as the download. The sums are
+	// added to the metadata, as check doesn't compute them.
+	ParallelHashes []string `json:"parallel_hashes,omitempty"`
+
+	// VersionPrecedence is a ordered list of version fields (etag, sha1,
+	// version), the first one that has a value is used as the version.
+	VersionPrecedence []string `json:"version_precedence,omitempty"`
+
+	// VersionStrategy selects a alternative way of versioning the URL,
+	// "response_time" versions it by its response time and "rss_pubdate"
+	// by the publication dates of the items in a RSS feed.
+	VersionStrategy string `json:"version_strategy,omitempty"`
+	// ResponseTimeThreshold is the duration a response time must exceed
+	// to be considered degraded.
+	ResponseTimeThreshold string `json:"response_time_threshold,omitempty"`
+	// ResponseTimeTolerancePct is the percentage a response time must
+	// have increased by compared to the current version to be
+	// considered degraded.
+	ResponseTimeTolerancePct float64 `json:"response_time_tolerance_pct,omitempty"`
+
+	// ChecksumURL is a checksum file that get verifies the download
+	// against, using the entry for the basename of URL.
+	ChecksumURL string `json:"checksum_url,omitempty"`
+	// ChecksumFileNameRegexp are tried in order to find the file name in
+	// each line of the checksum file, using the "filename" capture group.
+	// The name is compared case-insensitively. A "hash" capture group can
+	// be used to pick out the checksum.
+	ChecksumFileNameRegexp []string `json:"checksum_file_name_regexp,omitempty"`
+
+	// VersionIncludesSourceHash adds a hash of the source configuration
+	// that decides what is fetched, the URL, headers and mode options, to
+	// the version. Changes to it result in a new version.
+	VersionIncludesSourceHash bool `json:"version_includes_source_hash,omitempty"`
+
+	// AtomicOutput makes get write its output to a temporary directory
+	// that replaces the output directory once everything has succeeded.
+	// This is best-effort and depends on the filesystem semantics.
+	AtomicOutput bool `json:"atomic_output,omitempty"`
+
+	// GitHubAPIBase is the base URL of the GitHub API used by GitHub
+	// specific modes, defaults to "https://api.github.com". For GitHub
+	// Enterprise Server use "https://<host>/api/v3" and authenticate with
+	// a personal access token with the read:packages scope.
+	GitHubAPIBase string `json:"github_api_base,omitempty"`
+
+	// KubernetesServiceAccount authenticates with the projected service
+	// account token of the pod and trusts the cluster CA. The token file
+	// is re-read for every request.
+	KubernetesServiceAccount bool   `json:"kubernetes_service_account,omitempty"`
+	KubernetesSATokenFile    string `json:"kubernetes_sa_token_file,omitempty"`
+	KubernetesSACAFile       string `json:"kubernetes_sa_ca_file,omitempty"`
+
+	// Client can be set to inject a HTTP client, f.ex. one with a custom
+	// transport in tests. A client is created from the source otherwise.
+	Client *http.Client `json:"-"`
+}
+
+// validate checks the source configuration for errors that can be detected
+// before making any requests.
+func (s Source) validate() error {
+	if err := s.validateErrorPolicies(); err != nil {
+		return err
+	}
+
+	if _, _, err := s.tlsVersions(); err != nil {
+		return err
+	}
+
+	if s.GitHubAPIBase != "" {
+		if err := validateGitHubAPIBase(s.GitHubAPIBase); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// extractsVersion returns true if the version is extracted from the
+// response contents.
+func (s Source) extractsVersion() bool {
+	return s.VersionJSONPath != "" ||
+		s.HTMLMetaName != "" || s.HTMLMetaProperty != ""
+}
+
+// extractVersion extracts the version from the response contents.
+func (s Source) extractVersion(data []byte) (string, error) {
+	var version string
+	var err error
+
+	if s.VersionJSONPath != "" {
+		version, err = s.jsonPathVersion(data)
+	} else {
+		version, err = s.htmlMetaVersion(data)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	for _, prefix := range s.VersionTrimPrefixes {
+		if strings.HasPrefix(version, prefix) {
+			version = strings.TrimPrefix(version, prefix)
+			break
+		}
+	}
+
+	if s.CoerceSemver {
+		version, err = coerceSemver(version)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return s.limitVersion(version)
 }
 
 type BasicAuth struct {
@@ -143,45 +663,197 @@ type InCommand struct {
 	Source Source `json:"source"`
 	// Version is used in the implicit post `put` `get`
 	Version concourse.ResourceVersion
+	// Params passed to the get step
+	Params InParams `json:"params"`
+
+	response   *responseWriter
+	versionOut *os.File
+}
+
+// InParams are the parameters for the get step
+type InParams struct {
+	// VerifyFullChain logs and verifies every certificate in the chain
+	// presented by the server.
+	VerifyFullChain bool `json:"verify_full_chain"`
+	// VerifyCertHostname explicitly verifies and logs that the server
+	// certificate is valid for the host in the source URL.
+	VerifyCertHostname bool `json:"verify_cert_hostname"`
+	// RedactJSONFields are JSON paths of fields that are replaced with
+	// "[REDACTED]" in the downloaded JSON. The version is still based on
+	// the original contents.
+	RedactJSONFields []string `json:"redact_json_fields"`
+	// PipeOutput streams the download to stdout instead of writing it to
+	// a file, the version JSON is written to file descriptor 3 instead.
+	// This requires running the resource with fd 3 open, f.ex. in a task
+	// that runs "/opt/resource/in dir < request.json 3> version.json |
+	// consumer".
+	PipeOutput bool `json:"pipe_output"`
+	// PreserveTimestamps sets the modification time of the download to
+	// the Last-Modified time reported by the server.
+	PreserveTimestamps bool `json:"preserve_timestamps"`
+	// Base64EncodeBinary writes a base64 encoded copy of binary downloads
+	// to "downloaded.b64".
+	Base64EncodeBinary bool `json:"base64_encode_binary"`
+	// DirMode is a octal mode, f.ex. "0755", that's set on the output
+	// directory once the output has been written.
+	DirMode string `json:"dir_mode"`
+	// MarkExecutable sets the executable bits on downloaded files that
+	// are ELF, Mach-O or PE binaries or start with a shebang line.
+	MarkExecutable bool `json:"mark_executable"`
+	// ComputeAllHashes writes the md5, sha1, sha256 and sha512 sums of
+	// the download to downloaded.<algorithm> files and checksums.json.
+	ComputeAllHashes bool `json:"compute_all_hashes"`
+	// VerifyZip checks that the download is a well-formed zip file
+	// without path traversal or overlapping entries.
+	VerifyZip bool `json:"verify_zip"`
 }
 
 // HandleCommand runs the command
 func (cmd *InCommand) HandleCommand(ctx *concourse.CommandContext) (
 	*concourse.CommandResponse, error,
 ) {
+	var resp *concourse.CommandResponse
 	var err error
+
+	if cmd.Params.PipeOutput {
+		if err := cmd.validatePipeOutput(); err != nil {
+			return nil, err
+		}
+	}
+
+	var dirMode os.FileMode
+	if cmd.Params.DirMode != "" {
+		dirMode, err = parseDirMode(cmd.Params.DirMode)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cmd.Source.AtomicOutput {
+		resp, err = atomicOutput(ctx, cmd.in)
+	} else {
+		resp, err = cmd.in(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if cmd.Params.MarkExecutable {
+		marked, err := markExecutables(ctx.Directory())
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range marked {
+			resp.AddMeta("executable", name)
+		}
+	}
+
+	if cmd.Params.DirMode != "" {
+		err := os.Chmod(ctx.Directory(), dirMode)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to set output directory mode")
+		}
+	}
+
+	if cmd.Source.VersionIncludesSourceHash && resp.Version != nil {
+		sourceHash, err := cmd.Source.hash()
+		if err != nil {
+			return nil, err
+		}
+		resp.Version["source_hash"] = sourceHash
+	}
+
+	return resp, nil
+}
+
+// parseDirMode parses a octal mode of at most 07777. The setuid, setgid
+// and sticky bits are translated to their os.FileMode counterparts.
+func parseDirMode(value string) (os.FileMode, error) {
+	bits, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to parse dir_mode")
+	}
+	if bits > 07777 {
+		return 0, errors.Errorf("dir_mode %q is out of range", value)
+	}
+
+	mode := os.FileMode(bits) & os.ModePerm
+	if bits&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if bits&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if bits&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+
+	return mode, nil
+}
+
+func (cmd *InCommand) in(ctx *concourse.CommandContext) (
+	*concourse.CommandResponse, error,
+) {
 	var resp concourse.CommandResponse
 
 	etag := cmd.Version["etag"]
 	hash := cmd.Version["sha1"]
 
-	timeout := 5 * time.Minute
-	if cmd.Source.Timeout != "" {
-		timeout, err = time.ParseDuration(cmd.Source.Timeout)
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to parse timeout")
-		}
+	if cmd.Source.VersionNegate {
+		return cmd.inNegated(ctx)
 	}
 
-	client := http.Client{
-		Timeout: timeout,
+	if cmd.Source.VersionStrategy == "response_time" {
+		return cmd.inResponseTime(ctx)
 	}
 
-	req, err := http.NewRequest("GET", cmd.Source.URL, nil)
+	if err := cmd.Source.validate(); err != nil {
+		return nil, err
+	}
+
+	client, closeClient, err := cmd.Source.httpClient(ctx.Log)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create request")
+		return nil, err
 	}
+	defer closeClient()
 
-	// Add source headers
-	for name, values := range cmd.Source.Headers {
-		req.Header[name] = append(req.Header[name], values...)
+	if cmd.Source.DockerRegistry != nil {
+		return cmd.inDockerRegistry(ctx, client)
 	}
 
-	if cmd.Source.BasicAuth != nil {
-		req.SetBasicAuth(
-			cmd.Source.BasicAuth.User,
-			cmd.Source.BasicAuth.Password,
-		)
+	if cmd.Source.VersionStrategy == "rss_pubdate" {
+		return cmd.inRSSPubDate(ctx, client)
+	}
+
+	if cmd.Source.Mode == "maven-metadata" {
+		return cmd.inMavenMetadata(ctx, client)
+	}
+
+	if cmd.Source.Mode == "git-refs" {
+		return cmd.inGitRefs(ctx, client)
+	}
+
+	if cmd.Source.Mode == "nuget" {
+		return cmd.inNuGet(ctx, client)
+	}
+
+	if cmd.Source.Mode == "github-packages" {
+		return cmd.inGitHubPackages(ctx, client)
+	}
+
+	downloadURL := cmd.Source.URL
+	var pinnedS3Version bool
+	if cmd.Source.CaptureS3VersionID {
+		downloadURL, pinnedS3Version, err = cmd.Source.s3ObjectURL(
+			cmd.Version["s3_version_id"])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := cmd.Source.newRequest("GET", downloadURL, nil)
+	if err != nil {
+		return nil, err
 	}
 
 	res, err := client.Do(req)
@@ -190,6 +862,25 @@ func (cmd *InCommand) HandleCommand(ctx *concourse.CommandContext) (
 	}
 	defer res.Body.Close()
 
+	if cmd.Params.VerifyFullChain {
+		if err := verifyCertificateChain(res.TLS, ctx.Log); err != nil {
+			return nil, errors.Wrap(err, "failed to verify certificate chain")
+		}
+	}
+
+	if cmd.Params.VerifyCertHostname {
+		err := verifyCertificateHostname(res.TLS, cmd.Source.URL, ctx.Log)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to verify certificate hostname")
+		}
+	}
+
+	if cmd.Source.ErrorOnEmptyBody {
+		if err := ensureNonEmptyBody(res); err != nil {
+			return nil, err
+		}
+	}
+
 	version := concourse.ResourceVersion{}
 	responseETag := res.Header.Get("ETag")
 	if etag != "" && etag != responseETag {
@@ -200,37 +891,385 @@ func (cmd *InCommand) HandleCommand(ctx *concourse.CommandContext) (
 	if responseETag != "" {
 		version["etag"] = responseETag
 	}
+
+	if cmd.Source.SkipUnconditionalCheck {
+		if responseLastModified := res.Header.Get("Last-Modified"); responseLastModified != "" {
+			version["last-modified"] = responseLastModified
+		}
+	}
+
+	if cmd.Source.CaptureS3VersionID {
+		// Presigned URLs can't be pinned to a version, so the latest
+		// version has to match
+		versionID := res.Header.Get(s3VersionIDHeader)
+		expected := cmd.Version["s3_version_id"]
+		if !pinnedS3Version && expected != "" && versionID != expected {
+			return nil, errors.Errorf(
+				"unexpected S3 version ID %q, expected %q", versionID, expected,
+			)
+		}
+		if versionID == "" {
+			versionID = expected
+		}
+		if versionID != "" {
+			version["s3_version_id"] = versionID
+		}
+	}
+
+	if cmd.Params.PipeOutput {
+		return cmd.pipeOutput(res, version)
+	}
+
 	output, err := os.Create(filepath.Join(ctx.Directory(), "downloaded"))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create file for the download")
 	}
+	defer output.Close()
+
 	tee := io.TeeReader(res.Body, output)
 
+	hashes, err := newHashes(cmd.Source.ParallelHashes)
+	if err != nil {
+		return nil, err
+	}
+
 	h := sha1.New()
-	_, err = io.Copy(h, tee)
+	writers := []io.Writer{h}
+	for _, ph := range hashes {
+		writers = append(writers, ph)
+	}
+
+	var allHashNames []string
+	if cmd.Params.ComputeAllHashes {
+		allHashNames = standardHashes
+	}
+
+	allHashes, err := newHashes(allHashNames)
+	if err != nil {
+		return nil, err
+	}
+	for _, ah := range allHashes {
+		writers = append(writers, ah)
+	}
+
+	size, err := io.Copy(io.MultiWriter(writers...), tee)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to write out download")
 	}
 	version["sha1"] = fmt.Sprintf("%x", h.Sum(nil))
 
+	for _, name := range cmd.Source.ParallelHashes {
+		resp.AddMeta(name, fmt.Sprintf("%x", hashes[name].Sum(nil)))
+	}
+
+	if cmd.Params.ComputeAllHashes {
+		sums, err := writeHashFiles(ctx.Directory(), "downloaded", allHashes)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range standardHashes {
+			fmt.Fprintf(ctx.Log, "%s: %s\n", name, sums[name])
+		}
+	}
+
+	if cmd.Source.SizePrecheck {
+		version["size"] = strconv.FormatInt(size, 10)
+	}
+
 	if hash != "" && version["sha1"] != hash {
 		return nil, errors.Errorf("unexpected SHA1 content hash %q, expected %q",
 			version["sha1"], hash,
 		)
 	}
 
+	if cmd.Source.ChecksumURL != "" {
+		err := cmd.Source.verifyChecksumFile(client, ctx.Log, output.Name())
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to verify checksum")
+		}
+	}
+
+	if cmd.Params.VerifyZip {
+		if err := verifyZip(output.Name(), ctx.Log); err != nil {
+			return nil, errors.Wrap(err, "failed to verify zip file")
+		}
+	}
+
+	if cmd.Source.extractsVersion() {
+		data, err := ioutil.ReadFile(output.Name())
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read download")
+		}
+
+		version["version"], err = cmd.Source.extractVersion(data)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to extract version")
+		}
+
+		expected := cmd.Version["version"]
+		if expected != "" && version["version"] != expected {
+			return nil, errors.Errorf("unexpected version %q, expected %q",
+				version["version"], expected,
+			)
+		}
+	}
+
+	if len(cmd.Source.CompositeVersionHeaders) > 0 {
+		version["header_composite"] = cmd.Source.headerComposite(res.Header)
+
+		expected := cmd.Version["header_composite"]
+		if expected != "" && version["header_composite"] != expected {
+			return nil, errors.Errorf(
+				"unexpected header composite %q, expected %q",
+				version["header_composite"], expected,
+			)
+		}
+	}
+
+	if len(cmd.Source.VersionFields) > 0 {
+		data, err := ioutil.ReadFile(output.Name())
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read download")
+		}
+
+		fields, err := cmd.Source.fieldsVersion(res.Header, data)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to extract version fields")
+		}
+
+		for name, value := range fields {
+			expected := cmd.Version[name]
+			if expected != "" && value != expected {
+				return nil, errors.Errorf("unexpected %s %q, expected %q",
+					name, value, expected,
+				)
+			}
+			version[name] = value
+		}
+	}
+
+	if len(cmd.Params.RedactJSONFields) > 0 {
+		err := redactJSONFile(output.Name(), cmd.Params.RedactJSONFields)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to redact download")
+		}
+	}
+
 	resp.Version = version
+	if cmd.Source.CaptureS3VersionID {
+		setS3Version(&resp, version)
+	}
 	resp.AddMeta("content-type", res.Header.Get("Content-type"))
 
+	if cmd.Params.Base64EncodeBinary {
+		binary, err := isBinaryFile(output.Name(), res.Header.Get("Content-type"))
+		if err != nil {
+			return nil, err
+		}
+
+		if binary {
+			if err := writeBase64(output.Name(), output.Name()+".b64"); err != nil {
+				return nil, err
+			}
+		}
+		resp.AddMeta("binary", strconv.FormatBool(binary))
+	}
+
+	lastModified := res.Header.Get("Last-Modified")
+	if cmd.Params.PreserveTimestamps && lastModified != "" {
+		mtime, err := http.ParseTime(lastModified)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse Last-Modified")
+		}
+
+		if err := os.Chtimes(output.Name(), mtime, mtime); err != nil {
+			return nil, errors.Wrap(err, "failed to set modification time")
+		}
+
+		resp.AddMeta("last-modified", lastModified)
+		resp.AddMeta("mtime", mtime.UTC().Format(time.RFC3339))
+	}
+
 	return &resp, nil
 }
 
-// OutCommand in-command payload
-type OutCommand struct{}
+// OutCommand out-command payload
+type OutCommand struct {
+	// Source definition
+	Source Source `json:"source"`
+	// Params passed to the put step
+	Params OutParams `json:"params"`
+
+	contentType string
+}
+
+// OutParams are the parameters for the put step
+type OutParams struct {
+	// File is the path of the file to upload, relative to the build
+	// directory.
+	File string `json:"file"`
+	// Method is the HTTP method used for the upload, defaults to PUT.
+	Method string `json:"method"`
+	// ChunkSize splits the upload into requests of at most ChunkSize
+	// bytes with a Content-Range header.
+	ChunkSize int64 `json:"chunk_size"`
+	// FinalizeURL is POSTed to after all chunks have been uploaded.
+	FinalizeURL string `json:"finalize_url"`
+	// SendContentMD5 sends a Content-MD5 header with the upload and
+	// verifies MD5 ETags returned by the server.
+	SendContentMD5 bool `json:"send_content_md5"`
+	// VerifyAfterUpload downloads the uploaded resource again and
+	// compares its SHA1 with the uploaded file.
+	VerifyAfterUpload bool `json:"verify_after_upload"`
+	// Append appends the file to the existing resource with a PATCH
+	// request, using a HEAD request to find the current size.
+	Append bool `json:"append"`
+	// SignBeforeUpload creates a detached ASCII-armored signature with
+	// gpg using GPGSigningKey and uploads it with a PUT request to
+	// SignatureURLTemplate, a Go template with {{.URL}} available that
+	// defaults to the source URL with a ".asc" suffix. The passphrase is
+	// read from the environment variable named by GPGPassphraseEnv.
+	SignBeforeUpload     bool   `json:"sign_before_upload"`
+	GPGSigningKey        string `json:"gpg_signing_key"`
+	GPGPassphraseEnv     string `json:"gpg_passphrase_env"`
+	SignatureURLTemplate string `json:"signature_url_template"`
+	// MultipartThreshold switches to a multipart upload for files of at
+	// least this many bytes. The parts are ChunkSize bytes, 8MiB by
+	// default.
+	MultipartThreshold int64 `json:"multipart_threshold"`
+	// MultipartProtocol is the multipart upload protocol: "s3"
+	// (default), "azure" for block blobs or "gcs" for resumable uploads.
+	MultipartProtocol string `json:"multipart_protocol"`
+	// ContentType is the Content-Type of the upload. It's read from
+	// ContentTypeFile if not set, and otherwise detected from the file
+	// extension with a fallback to application/octet-stream.
+	ContentType     string `json:"content_type"`
+	ContentTypeFile string `json:"content_type_file"`
+}
 
 // HandleCommand runs the command
 func (cmd *OutCommand) HandleCommand(ctx *concourse.CommandContext) (
 	*concourse.CommandResponse, error,
 ) {
-	return nil, errors.New("not implemented")
+	var resp concourse.CommandResponse
+
+	if cmd.Params.File == "" {
+		return nil, errors.New("no file to upload specified")
+	}
+
+	if cmd.Params.Append && cmd.Params.VerifyAfterUpload {
+		return nil, errors.New(
+			"verify_after_upload can't be combined with append")
+	}
+
+	if err := cmd.Source.validate(); err != nil {
+		return nil, err
+	}
+
+	client, closeClient, err := cmd.Source.httpClient(ctx.Log)
+	if err != nil {
+		return nil, err
+	}
+	defer closeClient()
+
+	cmd.contentType, err = cmd.uploadContentType()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintln(ctx.Log, "content type:", cmd.contentType)
+
+	file, err := os.Open(cmd.Params.File)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open file for upload")
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to stat file for upload")
+	}
+
+	h := sha1.New()
+	m := md5.New()
+	if _, err := io.Copy(io.MultiWriter(h, m), file); err != nil {
+		return nil, errors.Wrap(err, "failed to hash file for upload")
+	}
+
+	if cmd.Params.SendContentMD5 {
+		fmt.Fprintf(ctx.Log, "content MD5: %x\n", m.Sum(nil))
+		resp.AddMeta("content-md5", fmt.Sprintf("%x", m.Sum(nil)))
+	}
+
+	var signature []byte
+	if cmd.Params.SignBeforeUpload {
+		signature, err = cmd.signFile(cmd.Params.File)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var etag string
+	var total int64
+	switch {
+	case cmd.Params.Append:
+		etag, total, err = cmd.uploadAppend(client, file, info.Size())
+	case cmd.Params.MultipartThreshold > 0 && info.Size() > 0 &&
+		info.Size() >= cmd.Params.MultipartThreshold:
+		etag, err = cmd.uploadMultipart(ctx, client, file, info.Size())
+	case cmd.Params.ChunkSize > 0 && info.Size() > 0:
+		etag, err = cmd.uploadChunked(ctx, client, file, info.Size())
+	default:
+		etag, err = cmd.upload(client, file, info.Size(), m.Sum(nil))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	version := concourse.ResourceVersion{
+		"sha1": fmt.Sprintf("%x", h.Sum(nil)),
+	}
+	if cmd.Params.Append {
+		// The file is only a part of the resource, so its hash can't
+		// be used as a version.
+		version = concourse.ResourceVersion{
+			"size": strconv.FormatInt(total, 10),
+		}
+		resp.AddMeta("total-size", version["size"])
+	}
+	if etag != "" {
+		version["etag"] = etag
+	}
+
+	if cmd.Params.SignBeforeUpload {
+		sigETag, err := cmd.uploadSignature(client, signature)
+		if err != nil {
+			return nil, err
+		}
+		if sigETag != "" {
+			version["signature_etag"] = sigETag
+		}
+	}
+
+	if cmd.Params.VerifyAfterUpload {
+		remote, err := cmd.remoteSHA1(client)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to download upload for verification")
+		}
+
+		resp.AddMeta("uploaded-sha1", version["sha1"])
+		resp.AddMeta("downloaded-sha1", remote)
+
+		if remote != version["sha1"] {
+			return nil, errors.Errorf(
+				"downloaded SHA1 %q doesn't match the uploaded %q",
+				remote, version["sha1"],
+			)
+		}
+	}
+
+	resp.Version = version
+	resp.AddMeta("file", cmd.Params.File)
+	resp.AddMeta("size", strconv.FormatInt(info.Size(), 10))
+
+	return &resp, nil
 }
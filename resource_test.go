@@ -0,0 +1,404 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Sydsvenskan/concourse"
+)
+
+const testBody = "hello world\n"
+
+// countingTransport counts the requests made through a injected client.
+type countingTransport struct {
+	Requests int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.Requests++
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func newTestServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Content-Type", "text/plain")
+			if _, err := w.Write([]byte(testBody)); err != nil {
+				t.Errorf("failed to write response: %v", err)
+			}
+		},
+	))
+}
+
+func newTestContext(t *testing.T, args ...string) *concourse.CommandContext {
+	var log bytes.Buffer
+	ctx, err := concourse.NewContext(args, nil, nil, &log)
+	if err != nil {
+		t.Fatalf("failed to create command context: %v", err)
+	}
+	return ctx
+}
+
+func TestCheckWithInjectedClient(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	transport := &countingTransport{}
+	source := Source{
+		URL:    srv.URL,
+		Client: &http.Client{Transport: transport},
+	}
+
+	cmd := CheckCommand{Source: source}
+	resp, err := cmd.HandleCommand(newTestContext(t, "check"))
+	if err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+
+	if len(resp.Versions) != 1 {
+		t.Fatalf("expected one version, got %v", resp.Versions)
+	}
+	version := resp.Versions[0]
+	if version["etag"] != `"v1"` {
+		t.Errorf("unexpected etag %q", version["etag"])
+	}
+
+	// The ETag makes the following check conditional
+	cmd = CheckCommand{Source: source, Version: version}
+	resp, err = cmd.HandleCommand(newTestContext(t, "check"))
+	if err != nil {
+		t.Fatalf("conditional check failed: %v", err)
+	}
+
+	if len(resp.Versions) != 1 || resp.Versions[0]["etag"] != `"v1"` {
+		t.Errorf("expected the current version, got %v", resp.Versions)
+	}
+
+	if transport.Requests != 2 {
+		t.Errorf("expected 2 requests through the injected client, got %d",
+			transport.Requests)
+	}
+}
+
+func TestInWithInjectedClient(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "url-resource-test")
+	if err != nil {
+		t.Fatalf("failed to create output directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	transport := &countingTransport{}
+	cmd := InCommand{
+		Source: Source{
+			URL:    srv.URL,
+			Client: &http.Client{Transport: transport},
+		},
+	}
+
+	resp, err := cmd.HandleCommand(newTestContext(t, "in", dir))
+	if err != nil {
+		t.Fatalf("in failed: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "downloaded"))
+	if err != nil {
+		t.Fatalf("failed to read download: %v", err)
+	}
+	if string(data) != testBody {
+		t.Errorf("unexpected download contents %q", data)
+	}
+
+	if resp.Version["sha1"] != "22596363b3de40b06f981fb85d82312e8c0ed511" {
+		t.Errorf("unexpected sha1 %q", resp.Version["sha1"])
+	}
+
+	if transport.Requests != 1 {
+		t.Errorf("expected 1 request through the injected client, got %d",
+			transport.Requests)
+	}
+}
+
+// trustingClient creates a client from the source that trusts the
+// certificate of the TLS test server.
+func trustingClient(t *testing.T, source Source, srv *httptest.Server) *http.Client {
+	client, err := NewHTTPClient(source)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	roots := srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	client.Transport.(*http.Transport).TLSClientConfig.RootCAs = roots
+
+	return client
+}
+
+func TestRedirectRestriction(t *testing.T) {
+	plain := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, testBody)
+		},
+	))
+	defer plain.Close()
+
+	var secure *httptest.Server
+	secure = httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/to-http":
+				http.Redirect(w, r, plain.URL, http.StatusFound)
+			case "/to-https":
+				http.Redirect(w, r, secure.URL+"/target", http.StatusFound)
+			default:
+				fmt.Fprint(w, testBody)
+			}
+		},
+	))
+	defer secure.Close()
+
+	deny := false
+	cases := []struct {
+		name  string
+		path  string
+		allow *bool
+		err   bool
+	}{
+		{name: "https to http by default", path: "/to-http"},
+		{name: "https to http refused", path: "/to-http", allow: &deny, err: true},
+		{name: "https to https", path: "/to-https", allow: &deny},
+	}
+
+	for _, c := range cases {
+		source := Source{
+			URL:                secure.URL + c.path,
+			AllowHTTPRedirects: c.allow,
+		}
+		source.Client = trustingClient(t, source, secure)
+
+		cmd := CheckCommand{Source: source}
+		_, err := cmd.HandleCommand(newTestContext(t, "check"))
+		if c.err && (err == nil || !strings.Contains(err.Error(), "not allowed")) {
+			t.Errorf("%s: expected the redirect to be refused, got %v", c.name, err)
+		}
+		if !c.err && err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+	}
+}
+
+func TestSNIBypass(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, testBody)
+		},
+	))
+	defer srv.Close()
+
+	// The test server is dialed by IP, which isn't sent with SNI
+	host := strings.TrimPrefix(srv.URL, "https://")
+	host = host[:strings.LastIndex(host, ":")]
+
+	cases := []struct {
+		name     string
+		hostname string
+		trusted  bool
+		err      bool
+	}{
+		{name: "bypassed host", hostname: host},
+		{name: "other host, untrusted", hostname: "bypassed.example", err: true},
+		{name: "other host, trusted", hostname: "bypassed.example", trusted: true},
+	}
+
+	for _, c := range cases {
+		source := Source{
+			URL:         srv.URL,
+			SNIBypass:   true,
+			SNIHostname: c.hostname,
+		}
+
+		client, err := NewHTTPClient(source)
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+		if c.trusted {
+			client = trustingClient(t, source, srv)
+		}
+		source.Client = client
+
+		cmd := CheckCommand{Source: source}
+		_, err = cmd.HandleCommand(newTestContext(t, "check"))
+		if c.err && err == nil {
+			t.Errorf("%s: expected certificate verification to fail", c.name)
+		}
+		if !c.err && err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+	}
+}
+
+func TestVerifyZipPathTraversal(t *testing.T) {
+	cases := []struct {
+		name  string
+		entry string
+		err   bool
+	}{
+		{name: "nested entry", entry: "dir/file.txt"},
+		{name: "parent directory", entry: "../evil.txt", err: true},
+		{name: "nested parent directory", entry: "dir/../../evil.txt", err: true},
+		{name: "absolute path", entry: "/etc/evil", err: true},
+		{name: "backslashes", entry: `..\evil.txt`, err: true},
+		{name: "drive letter", entry: `C:\evil.txt`, err: true},
+	}
+
+	for _, c := range cases {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		w, err := zw.Create(c.entry)
+		if err != nil {
+			t.Fatalf("%s: failed to create zip entry: %v", c.name, err)
+		}
+		fmt.Fprint(w, testBody)
+		if err := zw.Close(); err != nil {
+			t.Fatalf("%s: failed to write zip: %v", c.name, err)
+		}
+
+		srv := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write(buf.Bytes())
+			},
+		))
+
+		cmd := InCommand{
+			Source: Source{URL: srv.URL, Client: srv.Client()},
+			Params: InParams{VerifyZip: true},
+		}
+		_, err = cmd.HandleCommand(newTestContext(t, "in", t.TempDir()))
+		srv.Close()
+
+		if c.err && err == nil {
+			t.Errorf("%s: expected %q to be rejected", c.name, c.entry)
+		}
+		if !c.err && err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+	}
+}
+
+func TestRedactJSONFields(t *testing.T) {
+	const doc = `{"user":"alice","auth":{"token":"secret-token"},` +
+		`"keys":["secret-key","public-key"],"odd key":"secret-odd"}`
+
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, doc)
+		},
+	))
+	defer srv.Close()
+
+	cases := []struct {
+		name   string
+		path   string
+		secret string
+	}{
+		{name: "nested key", path: "$.auth.token", secret: "secret-token"},
+		{name: "array index", path: "$.keys[0]", secret: "secret-key"},
+		{name: "quoted key", path: "$['odd key']", secret: "secret-odd"},
+	}
+
+	for _, c := range cases {
+		dir := t.TempDir()
+		cmd := InCommand{
+			Source: Source{URL: srv.URL, Client: srv.Client()},
+			Params: InParams{RedactJSONFields: []string{c.path}},
+		}
+
+		resp, err := cmd.HandleCommand(newTestContext(t, "in", dir))
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, "downloaded"))
+		if err != nil {
+			t.Fatalf("%s: failed to read download: %v", c.name, err)
+		}
+		if strings.Contains(string(data), c.secret) {
+			t.Errorf("%s: %q wasn't redacted: %s", c.name, c.secret, data)
+		}
+		if !strings.Contains(string(data), "[REDACTED]") ||
+			!strings.Contains(string(data), "alice") {
+			t.Errorf("%s: unexpected redacted document %s", c.name, data)
+		}
+
+		// The version is based on the original contents
+		if want := fmt.Sprintf("%x", sha1.Sum([]byte(doc))); resp.Version["sha1"] != want {
+			t.Errorf("%s: unexpected sha1 %q, expected %q",
+				c.name, resp.Version["sha1"], want)
+		}
+	}
+}
+
+func TestRequestLogRedaction(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret-cookie"})
+			w.Header().Set("WWW-Authenticate", `Bearer realm="secret-realm"`)
+			fmt.Fprint(w, testBody)
+		},
+	))
+	defer srv.Close()
+
+	logFile := filepath.Join(t.TempDir(), "requests.log")
+	source := Source{
+		URL:        strings.Replace(srv.URL, "http://", "http://user:secret-userinfo@", 1) + "/?token=secret-query",
+		Headers:    http.Header{"X-Api-Key": {"secret-header"}},
+		BasicAuth:  &BasicAuth{User: "user", Password: "secret-password"},
+		RequestLog: logFile,
+	}
+
+	cmd := CheckCommand{Source: source}
+	if _, err := cmd.HandleCommand(newTestContext(t, "check")); err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("failed to read request log: %v", err)
+	}
+	if !strings.Contains(string(data), "REDACTED") {
+		t.Errorf("expected redacted values in the log: %s", data)
+	}
+
+	secrets := []string{
+		"secret-userinfo",
+		"secret-query",
+		"secret-header",
+		// The basic auth header is base64 encoded
+		base64.StdEncoding.EncodeToString([]byte("user:secret-password")),
+		"secret-cookie",
+		"secret-realm",
+	}
+	for _, secret := range secrets {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("%q was logged: %s", secret, data)
+		}
+	}
+}
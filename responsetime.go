@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Sydsvenskan/concourse"
+	"github.com/pkg/errors"
+)
+
+// checkResponseTime versions the URL by its response time. A new version
+// is emitted when the total response time exceeds the threshold and has
+// degraded by more than the tolerance compared to the current version.
+func (cmd *CheckCommand) checkResponseTime(client *http.Client) (
+	*concourse.CommandResponse, error,
+) {
+	var resp concourse.CommandResponse
+
+	if cmd.Version != nil {
+		resp.Versions = append(resp.Versions, cmd.Version)
+	}
+
+	var threshold time.Duration
+	if cmd.Source.ResponseTimeThreshold != "" {
+		var err error
+		threshold, err = time.ParseDuration(cmd.Source.ResponseTimeThreshold)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse response time threshold")
+		}
+	}
+
+	req, err := cmd.Source.newRequest("GET", cmd.Source.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to perform request")
+	}
+	defer res.Body.Close()
+	ttfb := time.Since(start)
+
+	// A fast error response says nothing about the response time
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, statusError(res, "unexpected response status %q", res.Status)
+	}
+
+	if _, err := io.Copy(ioutil.Discard, res.Body); err != nil {
+		return nil, errors.Wrap(err, "failed to read response contents")
+	}
+	total := time.Since(start)
+
+	version := concourse.ResourceVersion{
+		"ttfb_ms":  strconv.FormatInt(int64(ttfb/time.Millisecond), 10),
+		"total_ms": strconv.FormatInt(int64(total/time.Millisecond), 10),
+	}
+
+	if cmd.Version != nil {
+		if total <= threshold {
+			return &resp, nil
+		}
+
+		previous, err := strconv.ParseInt(cmd.Version["total_ms"], 10, 64)
+		if err == nil {
+			limit := float64(previous) * (1 + cmd.Source.ResponseTimeTolerancePct/100)
+			if float64(total/time.Millisecond) <= limit {
+				return &resp, nil
+			}
+		}
+	}
+
+	resp.Versions = []concourse.ResourceVersion{
+		version,
+	}
+
+	return &resp, nil
+}
+
+// inResponseTime writes out the measured response times of a version.
+func (cmd *InCommand) inResponseTime(ctx *concourse.CommandContext) (
+	*concourse.CommandResponse, error,
+) {
+	var resp concourse.CommandResponse
+
+	if err := ctx.JSON("response_time.json", cmd.Version); err != nil {
+		return nil, err
+	}
+
+	resp.Version = cmd.Version
+	resp.AddMeta("ttfb_ms", cmd.Version["ttfb_ms"])
+	resp.AddMeta("total_ms", cmd.Version["total_ms"])
+
+	return &resp, nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Sydsvenskan/concourse"
+	"github.com/pkg/errors"
+)
+
+type rssFeed struct {
+	Channel struct {
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+type rssItem struct {
+	Title     string `xml:"title"`
+	Link      string `xml:"link"`
+	PubDate   string `xml:"pubDate"`
+	Enclosure struct {
+		URL string `xml:"url,attr"`
+	} `xml:"enclosure"`
+
+	Published time.Time `xml:"-"`
+}
+
+// rssDateFormats are the RFC 1123 and RFC 2822 date variations seen in
+// pubDate elements.
+var rssDateFormats = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 MST",
+	time.RFC822Z,
+	time.RFC822,
+}
+
+func parsePubDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, format := range rssDateFormats {
+		t, err := time.Parse(format, value)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.Errorf("unrecognised pubDate %q", value)
+}
+
+// fetchRSSItems fetches the feed and returns its items with parsed
+// publication dates, most recent first.
+func (s Source) fetchRSSItems(client *http.Client) ([]rssItem, error) {
+	req, err := s.newRequest("GET", s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch feed")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, statusError(res,
+			"unexpected response status %q for feed", res.Status)
+	}
+
+	var feed rssFeed
+	if err := xml.NewDecoder(res.Body).Decode(&feed); err != nil {
+		return nil, errors.Wrap(err, "failed to decode feed")
+	}
+
+	items := feed.Channel.Items
+	for i := range items {
+		items[i].Published, err = parsePubDate(items[i].PubDate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Published.After(items[j].Published)
+	})
+
+	return items, nil
+}
+
+func (cmd *CheckCommand) checkRSSPubDate(client *http.Client) (
+	*concourse.CommandResponse, error,
+) {
+	var resp concourse.CommandResponse
+
+	if cmd.Version != nil {
+		resp.Versions = append(resp.Versions, cmd.Version)
+	}
+
+	items, err := cmd.Source.fetchRSSItems(client)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return &resp, nil
+	}
+
+	if cmd.Version == nil {
+		resp.Versions = []concourse.ResourceVersion{
+			rssVersion(items[0]),
+		}
+		return &resp, nil
+	}
+
+	current, err := time.Parse(time.RFC3339, cmd.Version["published"])
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse current version")
+	}
+
+	var versions []concourse.ResourceVersion
+	for i := len(items) - 1; i >= 0; i-- {
+		if items[i].Published.After(current) {
+			versions = append(versions, rssVersion(items[i]))
+		}
+	}
+	if len(versions) > 0 {
+		resp.Versions = versions
+	}
+
+	return &resp, nil
+}
+
+func rssVersion(item rssItem) concourse.ResourceVersion {
+	return concourse.ResourceVersion{
+		"published": item.Published.UTC().Format(time.RFC3339),
+	}
+}
+
+func (cmd *InCommand) inRSSPubDate(
+	ctx *concourse.CommandContext, client *http.Client,
+) (*concourse.CommandResponse, error) {
+	var resp concourse.CommandResponse
+
+	items, err := cmd.Source.fetchRSSItems(client)
+	if err != nil {
+		return nil, err
+	}
+
+	var item *rssItem
+	for i := range items {
+		if rssVersion(items[i])["published"] == cmd.Version["published"] {
+			item = &items[i]
+			break
+		}
+	}
+	if item == nil {
+		return nil, errors.Errorf(
+			"no item published at %s in the feed", cmd.Version["published"])
+	}
+
+	link := item.Enclosure.URL
+	if link == "" {
+		link = item.Link
+	}
+	if link == "" {
+		return nil, errors.New("the item has neither an enclosure nor a link")
+	}
+
+	res, sum, err := cmd.Source.download(client, link,
+		filepath.Join(ctx.Directory(), "downloaded"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Version = rssVersion(*item)
+	resp.AddMeta("title", item.Title)
+	resp.AddMeta("url", link)
+	resp.AddMeta("sha1", sum)
+	resp.AddMeta("content-type", res.Header.Get("Content-type"))
+
+	return &resp, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/Sydsvenskan/concourse"
+	"github.com/pkg/errors"
+)
+
+// s3VersionIDHeader is returned by S3 for objects in versioned buckets.
+const s3VersionIDHeader = "X-Amz-Version-Id"
+
+// checkS3VersionID versions the URL by the S3 object version ID reported
+// for a HEAD request, so that the object doesn't have to be downloaded.
+func (cmd *CheckCommand) checkS3VersionID(client *http.Client) (
+	*concourse.CommandResponse, error,
+) {
+	var resp concourse.CommandResponse
+
+	if cmd.Version != nil {
+		resp.Versions = append(resp.Versions, cmd.Version)
+	}
+
+	req, err := cmd.Source.newRequest("HEAD", cmd.Source.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to perform HEAD request")
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, statusError(res, "unexpected response status %q", res.Status)
+	}
+
+	versionID := res.Header.Get(s3VersionIDHeader)
+	if versionID == "" {
+		return nil, errors.New(
+			"no S3 version ID in the response, is versioning enabled for the bucket?")
+	}
+
+	if versionID == cmd.Version["s3_version_id"] {
+		return &resp, nil
+	}
+
+	resp.Versions = append(resp.Versions, concourse.ResourceVersion{
+		"s3_version_id": versionID,
+	})
+
+	return &resp, nil
+}
+
+// s3ObjectURL returns the URL of the object version that's identified by
+// versionID. The URL is returned as is for presigned URLs, as the query
+// can't be changed without invalidating the signature, and pinned is then
+// false.
+func (s Source) s3ObjectURL(versionID string) (objectURL string, pinned bool, err error) {
+	if versionID == "" {
+		return s.URL, false, nil
+	}
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return "", false, errors.Wrap(err, "failed to parse URL")
+	}
+
+	query := u.Query()
+	if query.Get("X-Amz-Signature") != "" || query.Get("Signature") != "" {
+		return s.URL, false, nil
+	}
+
+	objectURL, err = withQuery(s.URL, url.Values{"versionId": {versionID}})
+	if err != nil {
+		return "", false, err
+	}
+
+	return objectURL, true, nil
+}
+
+// setS3Version sets the response version to the S3 version ID alone, so
+// that it matches the versions emitted by check. The rest of the version
+// is added to the metadata.
+func setS3Version(resp *concourse.CommandResponse, version concourse.ResourceVersion) {
+	resp.Version = concourse.ResourceVersion{
+		"s3_version_id": version["s3_version_id"],
+	}
+
+	var names []string
+	for name := range version {
+		if name != "s3_version_id" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		resp.AddMeta(name, version[name])
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/mod/semver"
+)
+
+// semverOf returns s with the "v" prefix that x/mod/semver expects, and
+// whether it's a complete semantic version. The "v1" and "v1.2" shorthands
+// that x/mod/semver accepts aren't.
+func semverOf(s string) (string, bool) {
+	v := "v" + strings.TrimPrefix(s, "v")
+	return v, semver.IsValid(v) &&
+		semver.Canonical(v) == strings.SplitN(v, "+", 2)[0]
+}
+
+var semverCoercePattern = regexp.MustCompile(`(\d+)(?:\.(\d+))?(?:\.(\d+))?`)
+
+// coerceSemver normalises a version string to a semantic version. Valid
+// semantic versions are returned without the "v" prefix, otherwise the
+// first "MAJOR[.MINOR[.PATCH]]" sequence of digits is used with missing
+// components set to zero.
+func coerceSemver(raw string) (string, error) {
+	trimmed := strings.TrimPrefix(strings.TrimPrefix(raw, "v"), "V")
+	if _, ok := semverOf(trimmed); ok {
+		return trimmed, nil
+	}
+
+	m := semverCoercePattern.FindStringSubmatch(trimmed)
+	if m == nil {
+		return "", errors.Errorf("can't coerce %q to a semantic version", raw)
+	}
+
+	nums := make([]int, 3)
+	for i, part := range m[1:] {
+		if part == "" {
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return "", errors.Errorf("can't coerce %q to a semantic version", raw)
+		}
+		nums[i] = n
+	}
+
+	return fmt.Sprintf("%d.%d.%d", nums[0], nums[1], nums[2]), nil
+}
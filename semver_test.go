@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestCoerceSemver(t *testing.T) {
+	cases := []struct {
+		raw  string
+		want string
+		err  bool
+	}{
+		{raw: "1.2.3", want: "1.2.3"},
+		{raw: "v1.2.3-rc.1+build.5", want: "1.2.3-rc.1+build.5"},
+		{raw: "v2.3", want: "2.3.0"},
+		{raw: "r1234", want: "1234.0.0"},
+		{raw: "4.2.1.Final", want: "4.2.1"},
+		{raw: "latest", err: true},
+	}
+
+	for _, c := range cases {
+		got, err := coerceSemver(c.raw)
+		if c.err {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %q", c.raw, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", c.raw, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%q: got %q, expected %q", c.raw, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// signFile creates a detached ASCII-armored signature for the file using
+// gpg with a temporary keyring, returning the signature.
+func (cmd *OutCommand) signFile(filename string) ([]byte, error) {
+	if cmd.Params.GPGSigningKey == "" {
+		return nil, errors.New("sign_before_upload requires gpg_signing_key")
+	}
+
+	home, err := ioutil.TempDir("", "url-resource-gpg-")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temporary gpg home")
+	}
+	defer os.RemoveAll(home)
+
+	err = runGPG(home, strings.NewReader(cmd.Params.GPGSigningKey),
+		"--import")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to import signing key")
+	}
+
+	sigFile := filepath.Join(home, "signature.asc")
+	args := []string{"--armor", "--detach-sign", "--output", sigFile}
+
+	var passphrase string
+	if cmd.Params.GPGPassphraseEnv != "" {
+		passphrase = os.Getenv(cmd.Params.GPGPassphraseEnv)
+		args = append([]string{
+			"--pinentry-mode", "loopback", "--passphrase-fd", "0",
+		}, args...)
+	}
+
+	err = runGPG(home, strings.NewReader(passphrase), append(args, filename)...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to sign file")
+	}
+
+	signature, err := ioutil.ReadFile(sigFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read signature")
+	}
+
+	return signature, nil
+}
+
+func runGPG(home string, stdin *strings.Reader, args ...string) error {
+	var stderr bytes.Buffer
+
+	c := exec.Command("gpg", append([]string{
+		"--batch", "--yes", "--homedir", home,
+	}, args...)...)
+	c.Stdin = stdin
+	c.Stderr = &stderr
+
+	if err := c.Run(); err != nil {
+		return errors.Wrap(err, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+// signatureURL expands the signature URL template, the signature is
+// uploaded next to the file with a ".asc" suffix by default.
+func (cmd *OutCommand) signatureURL() (string, error) {
+	if cmd.Params.SignatureURLTemplate == "" {
+		return cmd.Source.URL + ".asc", nil
+	}
+
+	tmpl, err := template.New("url").Parse(cmd.Params.SignatureURLTemplate)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to parse signature URL template")
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, struct{ URL string }{cmd.Source.URL})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to expand signature URL template")
+	}
+
+	return buf.String(), nil
+}
+
+// uploadSignature uploads the signature and returns its ETag.
+func (cmd *OutCommand) uploadSignature(
+	client *http.Client, signature []byte,
+) (string, error) {
+	sigURL, err := cmd.signatureURL()
+	if err != nil {
+		return "", err
+	}
+
+	req, err := cmd.Source.newRequest("PUT", sigURL, bytes.NewReader(signature))
+	if err != nil {
+		return "", err
+	}
+
+	res, err := doUploadRequest(client, req)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to upload signature")
+	}
+
+	return res.Header.Get("ETag"), nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// newTLSConfig creates the TLS configuration for the source.
+func newTLSConfig(source Source) (*tls.Config, error) {
+	config := &tls.Config{}
+
+	minVersion, maxVersion, err := source.tlsVersions()
+	if err != nil {
+		return nil, err
+	}
+	config.MinVersion = minVersion
+	config.MaxVersion = maxVersion
+
+	if source.KubernetesServiceAccount {
+		pool, err := systemCertPoolWith(source.kubernetesSACAFile())
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+
+	if source.TLSClientCert != "" || source.TLSClientKey != "" {
+		if source.TLSClientCert == "" || source.TLSClientKey == "" {
+			return nil, errors.New(
+				"both tls_client_cert and tls_client_key must be set")
+		}
+
+		if source.TLSClientCertReload {
+			// Certificates can be rotated on disk while we're running
+			config.GetClientCertificate = func(*tls.CertificateRequestInfo) (
+				*tls.Certificate, error,
+			) {
+				cert, err := tls.LoadX509KeyPair(
+					source.TLSClientCert, source.TLSClientKey,
+				)
+				if err != nil {
+					return nil, errors.Wrap(err, "failed to load client certificate")
+				}
+				return &cert, nil
+			}
+		} else {
+			cert, err := tls.LoadX509KeyPair(
+				source.TLSClientCert, source.TLSClientKey,
+			)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to load client certificate")
+			}
+			config.Certificates = []tls.Certificate{cert}
+		}
+	}
+
+	if source.SNIBypass {
+		if source.SNIHostname == "" {
+			return nil, errors.New("sni_bypass requires sni_hostname to be set")
+		}
+
+		u, err := url.Parse(source.URL)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse URL")
+		}
+		sourceHost := u.Hostname()
+
+		// Verification is done in VerifyConnection instead so that
+		// it can be skipped for the bypassed hostname only.
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			// The transport sets the dialed host as the server name
+			// of each connection, but crypto/tls doesn't report IP
+			// addresses as they can't be sent with SNI. Those are
+			// verified against the host of the source URL, so
+			// redirects to other IP addresses fail.
+			host := cs.ServerName
+			if host == "" {
+				host = sourceHost
+			}
+			if host == "" {
+				return errors.New("no hostname to verify the certificate against")
+			}
+
+			if host == source.SNIHostname {
+				return nil
+			}
+			return verifyConnection(cs, host, config.RootCAs)
+		}
+	}
+
+	return config, nil
+}
+
+// verifyConnection performs the standard certificate verification for host
+// against roots, or the system roots if nil.
+func verifyConnection(cs tls.ConnectionState, host string, roots *x509.CertPool) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("the server didn't present any certificates")
+	}
+
+	opts := x509.VerifyOptions{
+		DNSName:       host,
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsVersions returns the configured min and max TLS versions, zero
+// values leave the choice to crypto/tls.
+func (s Source) tlsVersions() (uint16, uint16, error) {
+	var minVersion, maxVersion uint16
+
+	if s.TLSMinVersion != "" {
+		v, ok := tlsVersions[s.TLSMinVersion]
+		if !ok {
+			return 0, 0, errors.Errorf(
+				"unknown tls_min_version %q, expected 1.0, 1.1, 1.2 or 1.3",
+				s.TLSMinVersion)
+		}
+		minVersion = v
+	}
+
+	if s.TLSMaxVersion != "" {
+		v, ok := tlsVersions[s.TLSMaxVersion]
+		if !ok {
+			return 0, 0, errors.Errorf(
+				"unknown tls_max_version %q, expected 1.0, 1.1, 1.2 or 1.3",
+				s.TLSMaxVersion)
+		}
+		maxVersion = v
+	}
+
+	if minVersion != 0 && maxVersion != 0 && minVersion > maxVersion {
+		return 0, 0, errors.Errorf(
+			"tls_min_version %s is higher than tls_max_version %s",
+			s.TLSMinVersion, s.TLSMaxVersion)
+	}
+
+	return minVersion, maxVersion, nil
+}
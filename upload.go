@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/Sydsvenskan/concourse"
+	"github.com/pkg/errors"
+)
+
+func (cmd *OutCommand) method() string {
+	if cmd.Params.Method != "" {
+		return cmd.Params.Method
+	}
+	return "PUT"
+}
+
+// upload sends the file in a single request and returns the ETag of the
+// uploaded resource, if any.
+func (cmd *OutCommand) upload(
+	client *http.Client, file io.ReadSeeker, size int64, md5sum []byte,
+) (string, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", errors.Wrap(err, "failed to rewind file for upload")
+	}
+
+	req, err := cmd.Source.newRequest(cmd.method(), cmd.Source.URL, file)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", cmd.contentType)
+
+	if cmd.Params.SendContentMD5 {
+		req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(md5sum))
+	}
+
+	res, err := doUploadRequest(client, req)
+	if err != nil {
+		return "", err
+	}
+
+	etag := res.Header.Get("ETag")
+	if cmd.Params.SendContentMD5 {
+		if err := verifyMD5ETag(etag, md5sum); err != nil {
+			return "", err
+		}
+	}
+
+	return etag, nil
+}
+
+// uploadChunked sends the file in chunks of the configured size, each with
+// a Content-Range header, and finalizes the upload if a finalize URL has
+// been configured.
+func (cmd *OutCommand) uploadChunked(
+	ctx *concourse.CommandContext, client *http.Client,
+	file io.ReaderAt, size int64,
+) (string, error) {
+	var etag string
+
+	for start := int64(0); start < size; start += cmd.Params.ChunkSize {
+		end := start + cmd.Params.ChunkSize
+		if end > size {
+			end = size
+		}
+
+		chunk := io.NewSectionReader(file, start, end-start)
+		req, err := cmd.Source.newRequest(cmd.method(), cmd.Source.URL, chunk)
+		if err != nil {
+			return "", err
+		}
+		req.ContentLength = end - start
+		req.Header.Set("Content-Type", cmd.contentType)
+		req.Header.Set("Content-Range",
+			fmt.Sprintf("bytes %d-%d/%d", start, end-1, size))
+
+		var md5sum []byte
+		if cmd.Params.SendContentMD5 {
+			m := md5.New()
+			if _, err := io.Copy(m, io.NewSectionReader(file, start, end-start)); err != nil {
+				return "", errors.Wrap(err, "failed to hash chunk")
+			}
+			md5sum = m.Sum(nil)
+			req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(md5sum))
+		}
+
+		res, err := doUploadRequest(client, req)
+		if err != nil {
+			return "", errors.Wrapf(err,
+				"failed to upload bytes %d-%d", start, end-1)
+		}
+		etag = res.Header.Get("ETag")
+
+		if cmd.Params.SendContentMD5 {
+			if err := verifyMD5ETag(etag, md5sum); err != nil {
+				return "", errors.Wrapf(err,
+					"failed to upload bytes %d-%d", start, end-1)
+			}
+		}
+
+		fmt.Fprintf(ctx.Log, "uploaded %d of %d bytes (%d%%)\n",
+			end, size, end*100/size)
+	}
+
+	if cmd.Params.FinalizeURL == "" {
+		return etag, nil
+	}
+
+	req, err := cmd.Source.newRequest("POST", cmd.Params.FinalizeURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := doUploadRequest(client, req)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to finalize upload")
+	}
+
+	return res.Header.Get("ETag"), nil
+}
+
+// uploadAppend appends the file to the end of the resource with a PATCH
+// request. The ETag and the new total size of the resource are returned.
+func (cmd *OutCommand) uploadAppend(
+	client *http.Client, file io.ReadSeeker, size int64,
+) (string, int64, error) {
+	req, err := cmd.Source.newRequest("HEAD", cmd.Source.URL, nil)
+	if err != nil {
+		return "", 0, err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "failed to perform HEAD request")
+	}
+	res.Body.Close()
+
+	var offset int64
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+	case res.StatusCode < 200 || res.StatusCode > 299:
+		return "", 0, errors.Errorf(
+			"unexpected response status %q for HEAD request", res.Status)
+	case res.ContentLength < 0:
+		return "", 0, errors.New("the server didn't report the current size")
+	default:
+		offset = res.ContentLength
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", 0, errors.Wrap(err, "failed to rewind file for upload")
+	}
+
+	req, err = cmd.Source.newRequest("PATCH", cmd.Source.URL, file)
+	if err != nil {
+		return "", 0, err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", cmd.contentType)
+
+	total := offset + size
+	if size > 0 {
+		req.Header.Set("Content-Range",
+			fmt.Sprintf("bytes %d-%d/%d", offset, total-1, total))
+	}
+
+	res, err = doUploadRequest(client, req)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "failed to append to resource")
+	}
+
+	return res.Header.Get("ETag"), total, nil
+}
+
+// remoteSHA1 downloads the uploaded resource and returns the SHA1 of its
+// contents.
+func (cmd *OutCommand) remoteSHA1(client *http.Client) (string, error) {
+	req, err := cmd.Source.newRequest("GET", cmd.Source.URL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to perform request")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", errors.Errorf("unexpected response status %q", res.Status)
+	}
+
+	h := sha1.New()
+	if _, err := io.Copy(h, res.Body); err != nil {
+		return "", errors.Wrap(err, "failed to hash response contents")
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// doUploadRequest performs a request and fails on non-2xx responses. The
+// response body is discarded.
+func doUploadRequest(client *http.Client, req *http.Request) (*http.Response, error) {
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to perform request")
+	}
+	defer res.Body.Close()
+
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, errors.Errorf("unexpected response status %q", res.Status)
+	}
+
+	return res, nil
+}
+
+// verifyMD5ETag compares an ETag with the MD5 of the uploaded content if the
+// ETag looks like a hex encoded MD5 sum, as returned by f.ex. S3.
+func verifyMD5ETag(etag string, md5sum []byte) error {
+	etag = strings.Trim(strings.TrimPrefix(etag, "W/"), `"`)
+	if len(etag) != 2*md5.Size {
+		return nil
+	}
+	if _, err := hex.DecodeString(etag); err != nil {
+		return nil
+	}
+
+	if !strings.EqualFold(etag, hex.EncodeToString(md5sum)) {
+		return errors.Errorf(
+			"server acknowledged MD5 %q, expected %x", etag, md5sum)
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+
+	"github.com/Sydsvenskan/concourse"
+	"github.com/pkg/errors"
+)
+
+// VersionField extracts a named version field from the response using
+// exactly one of a JSON path, a header name or a regexp matched against
+// the body.
+type VersionField struct {
+	Name     string `json:"name"`
+	JSONPath string `json:"jsonpath,omitempty"`
+	Header   string `json:"header,omitempty"`
+	// Regexp uses the first capture group if there is one, otherwise the
+	// whole match.
+	Regexp string `json:"regexp,omitempty"`
+}
+
+// fieldsVersion extracts the configured version fields into a composite
+// version.
+func (s Source) fieldsVersion(
+	header http.Header, data []byte,
+) (concourse.ResourceVersion, error) {
+	version := concourse.ResourceVersion{}
+
+	var doc interface{}
+	for _, field := range s.VersionFields {
+		if field.Name == "" {
+			return nil, errors.New("version fields must have a name")
+		}
+
+		var value string
+		switch {
+		case field.JSONPath != "":
+			if doc == nil {
+				var err error
+				doc, err = decodeJSON(data)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			v, ok, err := evalJSONPath(doc, field.JSONPath)
+			if err != nil {
+				return nil, err
+			}
+			if !ok || v == nil {
+				return nil, errors.Errorf(
+					"no value found for %q at %q", field.Name, field.JSONPath)
+			}
+
+			value, err = jsonValueString(v)
+			if err != nil {
+				return nil, err
+			}
+		case field.Header != "":
+			value = header.Get(field.Header)
+			if value == "" {
+				return nil, errors.Errorf(
+					"no %s header found for %q", field.Header, field.Name)
+			}
+		case field.Regexp != "":
+			re, err := regexp.Compile(field.Regexp)
+			if err != nil {
+				return nil, errors.Wrapf(err,
+					"failed to compile regexp for %q", field.Name)
+			}
+
+			m := re.FindSubmatch(data)
+			if m == nil {
+				return nil, errors.Errorf(
+					"regexp for %q didn't match", field.Name)
+			}
+			value = string(m[0])
+			if len(m) > 1 {
+				value = string(m[1])
+			}
+		default:
+			return nil, errors.Errorf(
+				"version field %q needs a jsonpath, header or regexp", field.Name)
+		}
+
+		version[field.Name] = value
+	}
+
+	return version, nil
+}
+
+// sameFields returns true if all fields in version have the same value in
+// other.
+func sameFields(version, other concourse.ResourceVersion) bool {
+	for name, value := range version {
+		if other[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// headerComposite returns a SHA-256 of the composite version headers,
+// sorted by name for stability.
+func (s Source) headerComposite(header http.Header) string {
+	names := make([]string, len(s.CompositeVersionHeaders))
+	for i, name := range s.CompositeVersionHeaders {
+		names[i] = http.CanonicalHeaderKey(name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s: %s\n", name, header.Get(name))
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Sydsvenskan/concourse"
+	"github.com/pkg/errors"
+	"golang.org/x/mod/semver"
+)
+
+// versionSortType compares version field values of a type, values are
+// checked with valid before they're compared.
+type versionSortType struct {
+	valid   func(value string) bool
+	compare func(a, b string) int
+}
+
+var versionSortTypes = map[string]versionSortType{
+	"time": {
+		valid: func(value string) bool {
+			_, err := time.Parse(time.RFC3339, value)
+			return err == nil
+		},
+		compare: func(a, b string) int {
+			at, _ := time.Parse(time.RFC3339, a)
+			bt, _ := time.Parse(time.RFC3339, b)
+			switch {
+			case at.Before(bt):
+				return -1
+			case at.After(bt):
+				return 1
+			}
+			return 0
+		},
+	},
+	"semver": {
+		valid: func(value string) bool {
+			_, ok := semverOf(value)
+			return ok
+		},
+		compare: func(a, b string) int {
+			av, _ := semverOf(a)
+			bv, _ := semverOf(b)
+			return semver.Compare(av, bv)
+		},
+	},
+	"string": {
+		valid:   func(string) bool { return true },
+		compare: strings.Compare,
+	},
+}
+
+// inferredSortTypes are tried in order when no sort type is configured,
+// the first one that all values are valid for is used.
+var inferredSortTypes = []string{"time", "semver", "string"}
+
+// sortVersions sorts versions by the named version field, compared as
+// sortType: "time" (RFC3339), "semver" or "string". Without a sort type
+// the first one that all the values are valid for is used.
+func sortVersions(
+	versions []concourse.ResourceVersion, field, sortType, order string,
+) error {
+	if field == "" {
+		return nil
+	}
+
+	var desc bool
+	switch order {
+	case "", "asc":
+	case "desc":
+		desc = true
+	default:
+		return errors.Errorf("unknown version sort order %q", order)
+	}
+
+	var typ versionSortType
+	if sortType != "" {
+		var ok bool
+		typ, ok = versionSortTypes[sortType]
+		if !ok {
+			return errors.Errorf("unknown version sort type %q", sortType)
+		}
+
+		for _, v := range versions {
+			if !typ.valid(v[field]) {
+				return errors.Errorf(
+					"the %s %q isn't a valid %s", field, v[field], sortType)
+			}
+		}
+	} else {
+		values := make([]string, len(versions))
+		for i, v := range versions {
+			values[i] = v[field]
+		}
+		typ = inferSortType(values)
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		c := typ.compare(versions[i][field], versions[j][field])
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	})
+
+	return nil
+}
+
+// inferSortType returns the first of the inferred sort types that all the
+// values are valid for.
+func inferSortType(values []string) versionSortType {
+	for _, name := range inferredSortTypes {
+		typ := versionSortTypes[name]
+
+		valid := true
+		for _, value := range values {
+			if !typ.valid(value) {
+				valid = false
+				break
+			}
+		}
+		if valid {
+			return typ
+		}
+	}
+
+	return versionSortTypes["string"]
+}
+
+// precedenceVersion collects the candidate version fields for a response
+// and returns the first one in the configured precedence order that has a
+// value.
+func (s Source) precedenceVersion(res *http.Response) (string, string, error) {
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", "", errors.Wrap(err, "failed to read response contents")
+	}
+
+	candidates := map[string]string{
+		"etag": res.Header.Get("ETag"),
+		"sha1": fmt.Sprintf("%x", sha1.Sum(data)),
+	}
+
+	if s.extractsVersion() {
+		candidates["version"], err = s.extractVersion(data)
+		if err != nil {
+			return "", "", errors.Wrap(err, "failed to extract version")
+		}
+	}
+
+	for _, name := range s.VersionPrecedence {
+		if candidates[name] != "" {
+			return name, candidates[name], nil
+		}
+	}
+
+	return "", "", errors.Errorf(
+		"none of the version fields %v had a value", s.VersionPrecedence)
+}
+
+// hash returns a SHA-256 of the normalized configuration that decides what
+// is fetched. Options that only affect how it's fetched, like timeouts,
+// logging and debouncing, don't change the hash.
+func (s Source) hash() (string, error) {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to parse URL")
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+
+	headers := make(map[string][]string, len(s.Headers))
+	for name, values := range s.Headers {
+		name = http.CanonicalHeaderKey(name)
+		headers[name] = append(headers[name], values...)
+	}
+
+	// The fields are encoded in declaration order and the header names
+	// sorted, which makes the encoding canonical.
+	normalized := struct {
+		URL                 string
+		Headers             map[string][]string
+		DockerRegistry      *DockerRegistry
+		Mode                string
+		MavenPackaging      string
+		RefRegexp           string
+		DownloadURLTemplate string
+		PackageID           string
+		Prerelease          bool
+		Owner               string
+		PackageName         string
+		PackageType         string
+		GitHubAPIBase       string
+	}{
+		URL:                 u.String(),
+		Headers:             headers,
+		DockerRegistry:      s.DockerRegistry,
+		Mode:                s.Mode,
+		MavenPackaging:      s.MavenPackaging,
+		RefRegexp:           s.RefRegexp,
+		DownloadURLTemplate: s.DownloadURLTemplate,
+		PackageID:           s.PackageID,
+		Prerelease:          s.Prerelease,
+		Owner:               s.Owner,
+		PackageName:         s.PackageName,
+		PackageType:         s.PackageType,
+		GitHubAPIBase:       s.GitHubAPIBase,
+	}
+
+	data, err := json.Marshal(normalized)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode source for hashing")
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data)), nil
+}
+
+// withSourceHash sets the source hash of the versions, versions with a
+// different source hash are copied rather than modified.
+func withSourceHash(
+	versions []concourse.ResourceVersion, sourceHash string,
+) []concourse.ResourceVersion {
+	result := make([]concourse.ResourceVersion, len(versions))
+	for i, v := range versions {
+		if v["source_hash"] == sourceHash {
+			result[i] = v
+			continue
+		}
+
+		result[i] = concourse.ResourceVersion{"source_hash": sourceHash}
+		for key, value := range v {
+			if key != "source_hash" {
+				result[i][key] = value
+			}
+		}
+	}
+	return result
+}
+
+// filterVersions returns the versions whose string representation matches
+// the regexp.
+func filterVersions(
+	versions []concourse.ResourceVersion, expr string,
+) ([]concourse.ResourceVersion, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to compile version filter regexp")
+	}
+
+	filtered := []concourse.ResourceVersion{}
+	for _, v := range versions {
+		if re.MatchString(versionString(v)) {
+			filtered = append(filtered, v)
+		}
+	}
+
+	return filtered, nil
+}
+
+// versionString returns the value of single field versions, and the
+// sorted "name=value" pairs of other versions.
+func versionString(v concourse.ResourceVersion) string {
+	if len(v) == 1 {
+		for _, value := range v {
+			return value
+		}
+	}
+
+	pairs := make([]string, 0, len(v))
+	for name, value := range v {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, " ")
+}
+
+// debounce re-runs the check until the same new versions have been seen the
+// configured number of consecutive times. If they change in between the
+// current version is returned instead.
+func (cmd *CheckCommand) debounce(
+	ctx *concourse.CommandContext, resp *concourse.CommandResponse,
+) (*concourse.CommandResponse, error) {
+	if !hasNewVersions(resp.Versions, cmd.Version) {
+		return resp, nil
+	}
+
+	interval := 10 * time.Second
+	if cmd.Source.CheckDebounceInterval != "" {
+		var err error
+		interval, err = time.ParseDuration(cmd.Source.CheckDebounceInterval)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse debounce interval")
+		}
+	}
+
+	for seen := 1; seen < cmd.Source.CheckDebounceCount; seen++ {
+		fmt.Fprintf(ctx.Log, "new version seen %d of %d times, re-checking in %s\n",
+			seen, cmd.Source.CheckDebounceCount, interval)
+		time.Sleep(interval)
+
+		next, err := cmd.check(ctx)
+		if err != nil {
+			return cmd.handleCheckError(ctx, err)
+		}
+
+		if !sameIdentities(next.Versions, resp.Versions) {
+			fmt.Fprintln(ctx.Log, "versions changed while debouncing, ignoring them")
+
+			var unchanged concourse.CommandResponse
+			if cmd.Version != nil {
+				unchanged.Versions = append(unchanged.Versions, cmd.Version)
+			}
+			return &unchanged, nil
+		}
+	}
+
+	return resp, nil
+}
+
+// volatileVersionKeys are measurements that differ between every check, so
+// they don't identify a version.
+var volatileVersionKeys = []string{"ttfb_ms", "total_ms"}
+
+// sameIdentities compares versions while ignoring volatile keys.
+func sameIdentities(a, b []concourse.ResourceVersion) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if !reflect.DeepEqual(versionIdentity(a[i]), versionIdentity(b[i])) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// versionIdentity copies the version without the volatile keys.
+func versionIdentity(v concourse.ResourceVersion) concourse.ResourceVersion {
+	identity := concourse.ResourceVersion{}
+	for key, value := range v {
+		identity[key] = value
+	}
+	for _, key := range volatileVersionKeys {
+		delete(identity, key)
+	}
+	return identity
+}
+
+// alwaysEmit returns true if check must emit a version even though the
+// usual logic wouldn't, as there is no current version to bootstrap from.
+func (cmd *CheckCommand) alwaysEmit() bool {
+	return cmd.Source.CheckAlwaysEmit && len(cmd.Version) == 0
+}
+
+// hasNewVersions returns true if versions contains anything but the
+// current version.
+func hasNewVersions(versions []concourse.ResourceVersion, current concourse.ResourceVersion) bool {
+	for _, v := range versions {
+		if !reflect.DeepEqual(v, current) {
+			return true
+		}
+	}
+	return false
+}
+
+// jitter sleeps for a random duration up to the configured max jitter.
+func (cmd *CheckCommand) jitter(ctx *concourse.CommandContext) error {
+	maxDelay, err := time.ParseDuration(cmd.Source.CheckJitterMax)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse check jitter")
+	}
+	if maxDelay <= 0 {
+		return nil
+	}
+
+	seed := fnv.New64a()
+	_, _ = seed.Write([]byte(os.Getenv("HOSTNAME")))
+	rnd := rand.New(rand.NewSource(int64(seed.Sum64())))
+
+	delay := time.Duration(rnd.Int63n(int64(maxDelay) + 1))
+	fmt.Fprintf(ctx.Log, "sleeping %s before checking\n", delay)
+	time.Sleep(delay)
+
+	return nil
+}
+
+const defaultVersionMaxLength = 256
+
+// limitVersion applies the configured length limit to a extracted
+// version. Versions that are too long are replaced with a sha256 hex
+// digest of the full value unless the strategy is "error".
+func (s Source) limitVersion(version string) (string, error) {
+	maxLength := s.VersionMaxLength
+	if maxLength <= 0 {
+		maxLength = defaultVersionMaxLength
+	}
+
+	hashed := fmt.Sprintf("%x", sha256.Sum256([]byte(version)))
+
+	switch s.VersionTooLongStrategy {
+	case "use_hash":
+		return hashed, nil
+	case "", "truncate":
+		if len(version) > maxLength {
+			return hashed, nil
+		}
+	case "error":
+		if len(version) > maxLength {
+			return "", errors.Errorf(
+				"the version is %d characters long, the max length is %d",
+				len(version), maxLength)
+		}
+	default:
+		return "", errors.Errorf(
+			"unknown version too long strategy %q", s.VersionTooLongStrategy)
+	}
+
+	return version, nil
+}
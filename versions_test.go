@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/Sydsvenskan/concourse"
+)
+
+func TestSortVersions(t *testing.T) {
+	cases := []struct {
+		name     string
+		values   []string
+		sortType string
+		want     []string
+		err      bool
+	}{
+		{
+			name:   "inferred semver",
+			values: []string{"1.10.0", "1.9.0", "1.2.0-rc.1", "1.2.0"},
+			want:   []string{"1.2.0-rc.1", "1.2.0", "1.9.0", "1.10.0"},
+		},
+		{
+			name: "inferred time",
+			values: []string{
+				"2020-01-02T00:00:00+02:00", "2020-01-01T23:00:00Z",
+			},
+			want: []string{
+				"2020-01-02T00:00:00+02:00", "2020-01-01T23:00:00Z",
+			},
+		},
+		{
+			name:   "mixed values fall back to string",
+			values: []string{"1.10.0", "2020-01-01T00:00:00Z", "1.9.0"},
+			want:   []string{"1.10.0", "1.9.0", "2020-01-01T00:00:00Z"},
+		},
+		{
+			name:     "configured type",
+			values:   []string{"1.10.0", "1.9.0"},
+			sortType: "string",
+			want:     []string{"1.10.0", "1.9.0"},
+		},
+		{
+			name:     "invalid value for the configured type",
+			values:   []string{"1.10.0", "latest"},
+			sortType: "semver",
+			err:      true,
+		},
+	}
+
+	for _, c := range cases {
+		var versions []concourse.ResourceVersion
+		for _, value := range c.values {
+			versions = append(versions, concourse.ResourceVersion{"v": value})
+		}
+
+		err := sortVersions(versions, "v", c.sortType, "asc")
+		if c.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+
+		var got []string
+		for _, v := range versions {
+			got = append(got, v["v"])
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, expected %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestSourceHash(t *testing.T) {
+	base := Source{
+		URL:     "https://example.com/file",
+		Headers: http.Header{"X-Api-Key": {"secret"}},
+	}
+
+	cases := []struct {
+		name    string
+		modify  func(s *Source)
+		changed bool
+	}{
+		{"request log", func(s *Source) { s.RequestLog = "/tmp/log" }, false},
+		{"jitter", func(s *Source) { s.CheckJitterMax = "10s" }, false},
+		{"debounce", func(s *Source) { s.CheckDebounceCount = 3 }, false},
+		{"host case", func(s *Source) { s.URL = "https://EXAMPLE.com/file" }, false},
+		{"header case", func(s *Source) {
+			s.Headers = http.Header{"x-api-key": {"secret"}}
+		}, false},
+		{"url", func(s *Source) { s.URL = "https://example.com/other" }, true},
+		{"header", func(s *Source) {
+			s.Headers = http.Header{"X-Api-Key": {"other"}}
+		}, true},
+		{"mode", func(s *Source) { s.Mode = "git-refs" }, true},
+	}
+
+	want, err := base.hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range cases {
+		s := base
+		c.modify(&s)
+
+		got, err := s.hash()
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if (got != want) != c.changed {
+			t.Errorf("%s: expected changed to be %v", c.name, c.changed)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// zipDataRange is the byte range of the compressed data of a zip entry.
+type zipDataRange struct {
+	name       string
+	start, end int64
+}
+
+// verifyZip sanity checks the structure of a zip file without extracting
+// it, so that corrupt or malicious archives are caught early.
+func verifyZip(filename string, log io.Writer) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return errors.Wrap(err, "failed to open zip file")
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return errors.Wrap(err, "failed to stat zip file")
+	}
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return errors.Wrap(err, "failed to read zip magic")
+	}
+
+	// An empty archive only consists of the end of central directory
+	// record.
+	if !bytes.Equal(magic, []byte("PK\x03\x04")) &&
+		!bytes.Equal(magic, []byte("PK\x05\x06")) {
+		return errors.Errorf("invalid zip magic %q", magic)
+	}
+
+	r, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return errors.Wrap(err, "failed to read zip central directory")
+	}
+
+	ranges := make([]zipDataRange, 0, len(r.File))
+	for _, entry := range r.File {
+		if err := checkZipEntryName(entry.Name); err != nil {
+			return err
+		}
+
+		if entry.Method == zip.Store &&
+			entry.CompressedSize64 != entry.UncompressedSize64 {
+			return errors.Errorf(
+				"stored zip entry %q has a compressed size of %d bytes but a uncompressed size of %d bytes",
+				entry.Name, entry.CompressedSize64, entry.UncompressedSize64)
+		}
+
+		start, err := entry.DataOffset()
+		if err != nil {
+			return errors.Wrapf(err, "failed to read zip entry %q", entry.Name)
+		}
+
+		end := start + int64(entry.CompressedSize64)
+		if end > info.Size() || end < start {
+			return errors.Errorf(
+				"zip entry %q extends beyond the end of the file", entry.Name)
+		}
+
+		ranges = append(ranges, zipDataRange{
+			name: entry.Name, start: start, end: end,
+		})
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].start < ranges[j].start
+	})
+
+	for i := 1; i < len(ranges); i++ {
+		if ranges[i].start < ranges[i-1].end {
+			return errors.Errorf("zip entries %q and %q overlap",
+				ranges[i-1].name, ranges[i].name)
+		}
+	}
+
+	fmt.Fprintf(log, "verified zip file with %d entries\n", len(r.File))
+
+	return nil
+}
+
+// checkZipEntryName rejects absolute names and names that would end up
+// outside of the extraction directory.
+func checkZipEntryName(name string) error {
+	normalized := strings.Replace(name, `\`, "/", -1)
+
+	if strings.HasPrefix(normalized, "/") ||
+		(len(normalized) > 1 && normalized[1] == ':') {
+		return errors.Errorf("zip entry %q has a absolute path", name)
+	}
+
+	cleaned := path.Clean(normalized)
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return errors.Errorf("zip entry %q is outside of the archive root", name)
+	}
+
+	return nil
+}